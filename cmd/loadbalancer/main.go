@@ -1,16 +1,23 @@
 package main
 
 import (
+	"crypto/rand"
 	"flag"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"loadbalancer/internal/api"
 	"loadbalancer/internal/config"
+	"loadbalancer/internal/discovery"
 	"loadbalancer/internal/healthcheck"
 	"loadbalancer/internal/loadbalancer"
 	"loadbalancer/internal/logging"
 	"loadbalancer/internal/proxy"
 	"loadbalancer/internal/ratelimiter"
+	"loadbalancer/internal/router"
 	"loadbalancer/internal/server"
 	"loadbalancer/internal/storage"
 
@@ -32,34 +39,101 @@ func main() {
 		return
 	}
 
-	// Инициализация backend-серверов
-	var bs []loadbalancer.Backend
-	for _, backend := range cfg.Backends {
-		b, err := loadbalancer.NewBackend(backend.URL)
+	var secret []byte
+	if cfg.Sticky.Enabled {
+		secret = []byte(cfg.Sticky.Secret)
+		if len(secret) == 0 {
+			// Без заданного ключа cookie перестают действовать после перезапуска
+			secret = make([]byte, 32)
+			_, _ = rand.Read(secret)
+			logging.L.Warn("sticky secret isn't set, using random key")
+		}
+	}
+
+	// Пулы серверов, каждый со своим алгоритмом, proxy и health check
+	upstreams := map[string]*upstream{}
+	for _, uc := range cfg.Upstreams {
+		u, err := newUpstream(cfg, uc, secret)
 		if err != nil {
-			logging.L.Error("invalid backend URL", "url", backend.URL, "error", err)
+			logging.L.Error("invalid upstream", "upstream", uc.Name, "error", err)
 			return
 		}
-		bs = append(bs, b)
+		u.checker.Start()
+		defer u.checker.Stop()
+		upstreams[uc.Name] = u
 	}
 
-	// Выбор алгоритма балансировки
-	var sel loadbalancer.Selector
-	switch cfg.Algorithm {
-	case "least_conn":
-		sel = loadbalancer.NewLeastConnections(bs)
-	case "random":
-		sel = loadbalancer.NewRandom(bs)
-	default:
-		sel = loadbalancer.NewRoundRobin(bs)
+	// Правила маршрутизации, запросы без подходящего правила идут в пул default
+	var routes []router.Route
+	splits := map[string]*router.Split{}
+	for _, rc := range cfg.Routes {
+		rt := router.Route{
+			Name:       rc.Name,
+			Host:       rc.Host,
+			PathPrefix: rc.PathPrefix,
+			Headers:    rc.Headers,
+		}
+		if len(rc.Split) == 0 {
+			rt.Handler = upstreams[rc.Upstream].proxy
+		} else {
+			split, err := newSplit(rc, upstreams)
+			if err != nil {
+				logging.L.Error("invalid route split", "route", rc.Name, "error", err)
+				return
+			}
+			rt.Handler = split
+			splits[rc.Name] = split
+		}
+		for _, m := range rc.Methods {
+			rt.Methods = append(rt.Methods, strings.ToUpper(m))
+		}
+		if rc.PathRegex != "" {
+			if rt.PathRegex, err = regexp.Compile(rc.PathRegex); err != nil {
+				logging.L.Error("invalid route", "route", rc.Name, "error", err)
+				return
+			}
+		}
+		routes = append(routes, rt)
+	}
+	var fallback http.Handler
+	if u, ok := upstreams[config.DefaultUpstream]; ok {
+		fallback = u.proxy
 	}
+	rtr := router.New(routes, fallback)
 
-	px := proxy.New(sel)
+	// Обнаружение серверов через DNS
+	for _, t := range cfg.Discovery.DNS {
+		d, err := discovery.NewDNS(upstreams[t.Upstream].pool, discovery.Target{
+			Name:     t.Name,
+			Host:     t.Host,
+			SRV:      t.SRV,
+			Port:     t.Port,
+			Scheme:   t.Scheme,
+			Interval: t.Interval,
+			Spec: loadbalancer.Spec{
+				Weight:    t.Weight,
+				MaxConns:  t.MaxConns,
+				Zone:      t.Zone,
+				SlowStart: cfg.SlowStart,
+			},
+		}, nil)
+		if err != nil {
+			logging.L.Error("invalid dns discovery", "error", err)
+			return
+		}
+		d.Start()
+		defer d.Stop()
+	}
 
-	// healthcheck для проверки состояния бэкендов
-	checker := healthcheck.New(bs, cfg.HealthDuration())
-	checker.Start()
-	defer checker.Stop()
+	// Обнаружение серверов из файлов
+	for _, fs := range cfg.Discovery.Files {
+		f := discovery.NewFile(upstreams[fs.Upstream].pool, fs.Path, fs.Interval, loadbalancer.Spec{SlowStart: cfg.SlowStart})
+		if err := f.Start(); err != nil {
+			logging.L.Error("file discovery failed", "path", fs.Path, "error", err)
+			return
+		}
+		defer f.Stop()
+	}
 
 	// Подключение к базе данных
 	repo, err := storage.NewPostgres(cfg.GetDSN())
@@ -77,12 +151,268 @@ func main() {
 	apiHandler := api.NewHandler(rl)
 	apiHandler.Register(mux)
 
+	// API управления серверами и маршрутами слушает отдельный адрес:
+	// пул default по путям /backends, остальные под /upstreams/{name}
+	adminMux := http.NewServeMux()
+	for _, uc := range cfg.Upstreams {
+		u := upstreams[uc.Name]
+		prefix := ""
+		if uc.Name != config.DefaultUpstream {
+			prefix = "/upstreams/" + uc.Name
+		}
+		api.NewBackends(u.pool, loadbalancer.Spec{Weight: 1, SlowStart: cfg.SlowStart}, u.checker, u.proxy).RegisterAt(adminMux, prefix)
+	}
+
+	// Регистрация API изменения долей трафика
+	api.NewRoutes(splits).Register(adminMux)
+
 	// Регистрация основного хендлера
-	mux.Handle("/", server.BuildHandler(px, rl.Middleware))
+	mux.Handle("/", server.BuildHandler(rtr, rl.Middleware))
 
 	// Запуск HTTP-сервера
-	srv := server.New(cfg, mux)
+	srv := server.New(cfg, mux).WithAdmin(cfg.Admin.Addr, server.BuildAdminHandler(adminMux, cfg.Admin.Token))
 	if err := srv.Start(); err != nil {
 		logging.L.Error("server run failed", "error", err)
 	}
 }
+
+// Пул серверов вместе с его proxy и health check
+type upstream struct {
+	pool    *loadbalancer.Pool
+	proxy   *proxy.Proxy
+	checker *healthcheck.Checker
+}
+
+// Создает пул серверов по описанию из конфига. Общие параметры proxy
+// берутся из cfg, secret - ключ липких сессий, nil если они выключены
+func newUpstream(cfg *config.Config, uc config.Upstream, secret []byte) (*upstream, error) {
+	// Инициализация backend-серверов
+	var bs []loadbalancer.Backend
+	for _, backend := range uc.Backends {
+		b, err := loadbalancer.NewFromSpec(loadbalancer.Spec{
+			Name:      backend.Name,
+			URL:       backend.URL,
+			Weight:    backend.Weight,
+			Priority:  backend.Priority,
+			MaxConns:  backend.MaxConns,
+			Zone:      backend.Zone,
+			Draining:  backend.Draining,
+			SlowStart: cfg.SlowStart,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("backend %q: %w", backend.Name, err)
+		}
+		bs = append(bs, b)
+	}
+
+	// Выбор алгоритма балансировки с учетом групп приоритетов и зон
+	factory, err := newFactory(uc.Algorithm, *uc.Hash)
+	if err != nil {
+		return nil, err
+	}
+	zoned := func(bs []loadbalancer.Backend) loadbalancer.Selector {
+		return loadbalancer.NewZoneAware(bs, cfg.Locality.Zone, cfg.Locality.MinHealthy, factory)
+	}
+	pool, err := loadbalancer.NewPool(bs, func(bs []loadbalancer.Backend) loadbalancer.Selector {
+		return loadbalancer.NewTiered(bs, cfg.Failover.MinHealthy, zoned)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []proxy.Option{proxy.WithQueue(bs, cfg.Queue.Size, cfg.Queue.Timeout)}
+	if secret != nil {
+		// У каждого пула своя cookie, иначе переход клиента между пулами затирал бы привязку
+		cookie := cfg.Sticky.Cookie
+		if uc.Name != config.DefaultUpstream {
+			cookie += "_" + uc.Name
+		}
+		opts = append(opts, proxy.WithSticky(bs, cookie, secret, cfg.Sticky.TTL))
+	}
+
+	if o := cfg.Outlier; o.Enabled {
+		opts = append(opts, proxy.WithOutlierDetection(bs, proxy.OutlierConfig{
+			Consecutive5xx:           o.Consecutive5xx,
+			ConsecutiveGatewayErrors: o.ConsecutiveGatewayErrors,
+			ErrorRate:                o.ErrorRate,
+			MinRequests:              o.MinRequests,
+			Window:                   o.Window,
+			BaseEjection:             o.BaseEjection,
+			MaxEjection:              o.MaxEjection,
+			MaxEjectedPercent:        o.MaxEjectedPercent,
+		}))
+	}
+
+	if cb := cfg.Breaker; cb.Enabled {
+		opts = append(opts, proxy.WithCircuitBreaker(proxy.BreakerConfig{
+			ConsecutiveFailures: cb.ConsecutiveFailures,
+			FailureRatio:        cb.FailureRatio,
+			MinRequests:         cb.MinRequests,
+			Window:              cb.Window,
+			OpenTimeout:         cb.OpenTimeout,
+			HalfOpenRequests:    cb.HalfOpenRequests,
+		}))
+	}
+
+	opts = append(opts,
+		proxy.WithRetry(newRetryPolicy(cfg.Retry)),
+		proxy.WithTransport(proxy.TransportConfig{
+			MaxIdleConns:          cfg.Transport.MaxIdleConns,
+			MaxConnsPerHost:       cfg.Transport.MaxConnsPerHost,
+			IdleConnTimeout:       cfg.Transport.IdleConnTimeout,
+			DialTimeout:           cfg.Transport.DialTimeout,
+			KeepAlive:             cfg.Transport.KeepAlive,
+			TLSHandshakeTimeout:   cfg.Transport.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: cfg.Transport.ResponseHeaderTimeout,
+			DisableKeepAlives:     cfg.Transport.DisableKeepAlives,
+		}),
+	)
+
+	if h := cfg.Hedging; h.Enabled {
+		opts = append(opts, proxy.WithHedging(proxy.HedgePolicy{
+			Delay:      h.Delay,
+			Percentile: h.Percentile,
+			MaxRate:    h.MaxRate,
+		}))
+	}
+
+	px := proxy.New(pool, pool.List(), opts...)
+	pool.OnChange(px.SetBackends)
+
+	// healthcheck для проверки состояния бэкендов
+	hc := *uc.HealthCheck
+	probe, err := newProbe(hc, hc.Type)
+	if err != nil {
+		return nil, fmt.Errorf("health check: %w", err)
+	}
+	probes := map[string]healthcheck.Prober{}
+	for _, backend := range uc.Backends {
+		if backend.Check == "" || backend.Check == hc.Type {
+			continue
+		}
+		if probes[backend.Name], err = newProbe(hc, backend.Check); err != nil {
+			return nil, fmt.Errorf("health check of %q: %w", backend.Name, err)
+		}
+	}
+	checker := healthcheck.New(bs, hc.Interval,
+		healthcheck.WithProbe(probe),
+		healthcheck.WithBackendProbes(probes),
+		healthcheck.WithTimeout(hc.Timeout),
+		healthcheck.WithThresholds(hc.Rise, hc.Fall),
+		healthcheck.WithBackoff(hc.MaxBackoff),
+		healthcheck.WithHold(px.Ejected),
+		healthcheck.WithHistory(hc.History),
+	)
+	pool.OnChange(checker.SetBackends)
+
+	return &upstream{pool: pool, proxy: px, checker: checker}, nil
+}
+
+// Создает разделение трафика маршрута между пулами
+func newSplit(rc config.Route, upstreams map[string]*upstream) (*router.Split, error) {
+	var variants []router.Variant
+	for _, sp := range rc.Split {
+		variants = append(variants, router.Variant{
+			Name:    sp.Upstream,
+			Weight:  sp.Weight,
+			Handler: upstreams[sp.Upstream].proxy,
+		})
+	}
+	var key loadbalancer.KeyFunc
+	if rc.StickyBy != nil {
+		var err error
+		if key, err = loadbalancer.NewKeyFunc(rc.StickyBy.Key, rc.StickyBy.Name); err != nil {
+			return nil, err
+		}
+	}
+	return router.NewSplit(variants, key)
+}
+
+// Возвращает конструктор селектора для алгоритма из конфига
+func newFactory(algorithm string, hash config.Hash) (loadbalancer.Factory, error) {
+	// Ключ для hash-алгоритмов
+	key, err := loadbalancer.NewKeyFunc(hash.Key, hash.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case "least_conn":
+		return loadbalancer.NewLeastConnections, nil
+	case "random":
+		return loadbalancer.NewRandom, nil
+	case "p2c":
+		return loadbalancer.NewP2C, nil
+	case "weighted_round_robin":
+		return loadbalancer.NewWeightedRoundRobin, nil
+	case "consistent_hash":
+		return func(bs []loadbalancer.Backend) loadbalancer.Selector {
+			return loadbalancer.NewConsistentHash(bs, key, hash.Replicas)
+		}, nil
+	case "consistent_hash_bounded":
+		return func(bs []loadbalancer.Backend) loadbalancer.Selector {
+			return loadbalancer.NewBoundedHash(bs, key, hash.Replicas, hash.LoadFactor)
+		}, nil
+	case "maglev":
+		return func(bs []loadbalancer.Backend) loadbalancer.Selector {
+			return loadbalancer.NewMaglev(bs, key, hash.TableSize)
+		}, nil
+	default:
+		return loadbalancer.NewRoundRobin, nil
+	}
+}
+
+// Возвращает проверку серверов заданного типа по параметрам из конфига
+func newProbe(hc config.HealthCheck, typ string) (healthcheck.Prober, error) {
+	switch typ {
+	case "tcp":
+		return healthcheck.TCPProbe{}, nil
+	case "grpc":
+		return healthcheck.GRPCProbe{Service: hc.GRPCService}, nil
+	}
+
+	statuses, err := healthcheck.ParseStatusRanges(hc.ExpectedStatus)
+	if err != nil {
+		return nil, err
+	}
+	p := &healthcheck.HTTPProbe{
+		Method:       strings.ToUpper(hc.Method),
+		Path:         hc.Path,
+		Statuses:     statuses,
+		BodyContains: hc.BodyContains,
+		Headers:      http.Header{},
+	}
+	if hc.BodyRegex != "" {
+		if p.BodyRegex, err = regexp.Compile(hc.BodyRegex); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range hc.Headers {
+		p.Headers.Set(k, v)
+	}
+	return p, nil
+}
+
+// Возвращает политику повторных попыток по параметрам из конфига
+func newRetryPolicy(rc config.Retry) proxy.RetryPolicy {
+	policy := proxy.RetryPolicy{
+		Attempts:      rc.Attempts,
+		NonIdempotent: rc.NonIdempotent,
+		MaxBody:       rc.MaxBody,
+		PerTryTimeout: rc.PerTryTimeout,
+		Budget:        rc.Budget,
+	}
+	for _, on := range rc.RetryOn {
+		switch on {
+		case "connect_error":
+			policy.OnConnectError = true
+		case "timeout":
+			policy.OnTimeout = true
+		default:
+			// Коды ответа уже проверены при загрузке конфига
+			code, _ := strconv.Atoi(on)
+			policy.OnStatus = append(policy.OnStatus, code)
+		}
+	}
+	return policy
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"loadbalancer/internal/healthcheck"
+	"loadbalancer/internal/loadbalancer"
+	"loadbalancer/internal/proxy"
+)
+
+// Backends обрабатывает HTTP-запросы управления серверами пула
+type Backends struct {
+	pool    *loadbalancer.Pool
+	spec    loadbalancer.Spec    // Параметры по умолчанию для новых серверов
+	checker *healthcheck.Checker // Источник истории проверок, nil если проверок нет
+	proxy   *proxy.Proxy         // Источник состояния автоматов защиты, nil если не нужен
+	prefix  string               // Префикс путей API, задается в RegisterAt
+}
+
+func NewBackends(pool *loadbalancer.Pool, defaults loadbalancer.Spec, checker *healthcheck.Checker, px *proxy.Proxy) *Backends {
+	return &Backends{pool: pool, spec: defaults, checker: checker, proxy: px}
+}
+
+func (h *Backends) Register(mux *http.ServeMux) {
+	h.RegisterAt(mux, "")
+}
+
+// Регистрирует API под префиксом, например /upstreams/api для пула api
+func (h *Backends) RegisterAt(mux *http.ServeMux, prefix string) {
+	h.prefix = prefix
+	mux.HandleFunc(prefix+"/backends", h.handleBackends)
+	mux.HandleFunc(prefix+"/backends/", h.handleBackend)
+	mux.HandleFunc(prefix+"/health/events", h.handleEvents)
+	mux.HandleFunc(prefix+"/queue", h.handleQueue)
+}
+
+// Состояние сервера в ответах API
+type backendView struct {
+	Name      string  `json:"name"`
+	URL       string  `json:"url"`
+	Alive     bool    `json:"alive"`
+	Draining  bool    `json:"draining"`
+	Conns     int64   `json:"conns"`
+	Weight    int     `json:"weight"`
+	Priority  int     `json:"priority"`
+	Zone      string  `json:"zone"`
+	MaxConns  int64   `json:"max_conns"`
+	Ramp      float64 `json:"ramp"`
+	LatencyMs float64 `json:"latency_ms"`
+
+	Breaker *proxy.BreakerStatus `json:"breaker,omitempty"` // Автомат защиты, если включен
+}
+
+func (h *Backends) viewOf(b loadbalancer.Backend) backendView {
+	v := backendView{
+		Name:      b.Name(),
+		URL:       b.URL().String(),
+		Alive:     b.Alive(),
+		Draining:  b.Draining(),
+		Conns:     b.Conns(),
+		Weight:    b.Weight(),
+		Priority:  b.Priority(),
+		Zone:      b.Zone(),
+		MaxConns:  b.MaxConns(),
+		Ramp:      b.Ramp(),
+		LatencyMs: float64(b.Latency().Microseconds()) / 1000,
+	}
+	if h.proxy != nil {
+		if st, ok := h.proxy.Breaker(b); ok {
+			v.Breaker = &st
+		}
+	}
+	return v
+}
+
+// Результат проверки сервера в ответах API
+type resultView struct {
+	Time      time.Time `json:"time"`
+	LatencyMs float64   `json:"latency_ms"`
+	Healthy   bool      `json:"healthy"`
+	Alive     bool      `json:"alive"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Записывает ответ в формате JSON
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Обрабатывает методы GET и POST по пути /backends
+func (h *Backends) handleBackends(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// Возвращаем состояние всех серверов
+		bs := h.pool.List()
+		out := make([]backendView, 0, len(bs))
+		for _, b := range bs {
+			out = append(out, h.viewOf(b))
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		// Добавление сервера, он начинает с прогрева
+		in := h.spec
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if in.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		b, err := loadbalancer.NewFromSpec(in)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b.StartRamp()
+		if err := h.pool.Add(b); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusCreated, h.viewOf(b))
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Обрабатывает GET по пути /queue: длина очереди относится ко всему пулу
+func (h *Backends) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	depth := 0
+	if h.proxy != nil {
+		depth = h.proxy.QueueDepth()
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"depth": depth})
+}
+
+// Обрабатывает GET, PATCH и DELETE по /backends/{name}
+// и GET по /backends/{name}/drain и /backends/{name}/health
+func (h *Backends) handleBackend(w http.ResponseWriter, r *http.Request) {
+	// Получаем имя сервера и подресурс из URL
+	name, sub, _ := strings.Cut(r.URL.Path[len(h.prefix+"/backends/"):], "/")
+	b := h.pool.Get(name)
+	if b == nil {
+		http.Error(w, "backend not found", http.StatusNotFound)
+		return
+	}
+
+	switch sub {
+	case "":
+	case "drain":
+		h.handleDrain(w, r, b)
+		return
+	case "health":
+		h.handleHealth(w, r, b)
+		return
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, h.viewOf(b))
+
+	case http.MethodPatch:
+		// Изменение веса, лимита соединений и режима вывода из работы,
+		// отсутствующие поля не меняются
+		var in struct {
+			Weight   *int   `json:"weight"`
+			MaxConns *int64 `json:"max_conns"`
+			Draining *bool  `json:"draining"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if in.Weight != nil && *in.Weight < 1 {
+			http.Error(w, "weight must be >= 1", http.StatusBadRequest)
+			return
+		}
+		if in.MaxConns != nil && *in.MaxConns < 0 {
+			http.Error(w, "max_conns must be >= 0", http.StatusBadRequest)
+			return
+		}
+		if in.Weight != nil {
+			b.SetWeight(*in.Weight)
+		}
+		if in.MaxConns != nil {
+			b.SetMaxConns(*in.MaxConns)
+		}
+		if in.Draining != nil {
+			b.SetDraining(*in.Draining)
+		}
+		writeJSON(w, http.StatusOK, h.viewOf(b))
+
+	case http.MethodDelete:
+		// Удаление сервера, запросы в обработке завершаются на нем
+		if _, err := h.pool.Remove(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Сообщает, завершены ли запросы на выводимом из работы сервере.
+// С параметром wait (например, ?wait=30s) ждет завершения не дольше указанного времени
+func (h *Backends) handleDrain(w http.ResponseWriter, r *http.Request, b loadbalancer.Backend) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wait = d
+	}
+
+	// Ожидание может быть дольше WriteTimeout сервера, продлеваем запись ответа
+	deadline := time.Now().Add(wait)
+	_ = http.NewResponseController(w).SetWriteDeadline(deadline.Add(10 * time.Second))
+	for b.Draining() && b.Conns() != 0 && time.Now().Before(deadline) {
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":     b.Name(),
+		"draining": b.Draining(),
+		"conns":    b.Conns(),
+		"drained":  b.Draining() && b.Conns() == 0,
+	})
+}
+
+// Возвращает текущий статус сервера и историю его проверок от старых к новым
+func (h *Backends) handleHealth(w http.ResponseWriter, r *http.Request, b loadbalancer.Backend) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var results []healthcheck.Result
+	if h.checker != nil {
+		results = h.checker.History(b.Name())
+	}
+	history := make([]resultView, 0, len(results))
+	for _, res := range results {
+		history = append(history, resultView{
+			Time:      res.Time,
+			LatencyMs: float64(res.Latency.Microseconds()) / 1000,
+			Healthy:   res.Healthy,
+			Alive:     res.Alive,
+			Error:     res.Error,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":    b.Name(),
+		"alive":   b.Alive(),
+		"history": history,
+	})
+}
+
+// Отправляет изменения статуса серверов потоком server-sent events
+func (h *Backends) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.checker == nil {
+		http.Error(w, "streaming unsupported", http.StatusNotImplemented)
+		return
+	}
+	// Поток живет дольше WriteTimeout сервера, снимаем ограничение на запись
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "streaming unsupported", http.StatusNotImplemented)
+		return
+	}
+
+	events, cancel := h.checker.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	// Комментарий раз в 15 секунд не дает прокси закрыть простаивающее соединение
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case e := <-events:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: health\ndata: %s\n\n", data)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
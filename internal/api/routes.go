@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"loadbalancer/internal/router"
+)
+
+// Routes обрабатывает HTTP-запросы управления разделением трафика маршрутов
+type Routes struct {
+	splits map[string]*router.Split // Маршрут - его разделение трафика
+}
+
+func NewRoutes(splits map[string]*router.Split) *Routes {
+	return &Routes{splits: splits}
+}
+
+func (h *Routes) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/routes", h.handleRoutes)
+	mux.HandleFunc("/routes/", h.handleRoute)
+}
+
+// Разделение трафика маршрута в ответах API
+type splitView struct {
+	Route    string                 `json:"route"`
+	Variants []router.VariantWeight `json:"variants"`
+}
+
+// Обрабатывает GET по пути /routes
+func (h *Routes) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	names := make([]string, 0, len(h.splits))
+	for name := range h.splits {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	out := make([]splitView, 0, len(names))
+	for _, name := range names {
+		out = append(out, splitView{Route: name, Variants: h.splits[name].Weights()})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// Обрабатывает GET и PATCH по /routes/{name}
+func (h *Routes) handleRoute(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/routes/")
+	s, ok := h.splits[name]
+	if !ok {
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, splitView{Route: name, Variants: s.Weights()})
+
+	case http.MethodPatch:
+		// Изменение весов вариантов, например {"weights": {"stable": 90, "canary": 10}},
+		// отсутствующие варианты сохраняют вес
+		var in struct {
+			Weights map[string]int `json:"weights"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.SetWeights(in.Weights); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, splitView{Route: name, Variants: s.Weights()})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -10,8 +13,14 @@ import (
 
 // Описывает один backend сервер
 type Backend struct {
-	Name string `yaml:"name"`
-	URL  string `yaml:"url"`
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Weight   int    `yaml:"weight"`    // Вес сервера для weighted_round_robin, по умолчанию 1
+	Priority int    `yaml:"priority"`  // Группа приоритета, 0 - основная
+	MaxConns int64  `yaml:"max_conns"` // Лимит активных соединений, 0 - без лимита
+	Zone     string `yaml:"zone"`      // Зона доступности
+	Draining bool   `yaml:"draining"`  // Выведен из работы: новые запросы не получает
+	Check    string `yaml:"check"`     // Тип проверки: http, tcp, grpc; по умолчанию health_check.type
 }
 
 // Описывает лимиты токенов по умолчанию для клиентов
@@ -20,14 +29,208 @@ type RateLimit struct {
 	RatePerSec int64 `yaml:"rate_per_sec"` // Скорость пополнения токенов в секунду
 }
 
+// Описывает ключ для hash-алгоритмов балансировки
+type Hash struct {
+	Key        string  `yaml:"key"`         // Источник ключа: header, cookie, query, path, ip
+	Name       string  `yaml:"name"`        // Имя заголовка, cookie или query-параметра
+	Replicas   int     `yaml:"replicas"`    // Количество виртуальных узлов на сервер
+	TableSize  int     `yaml:"table_size"`  // Размер таблицы maglev
+	LoadFactor float64 `yaml:"load_factor"` // Допустимое превышение средней нагрузки для consistent_hash_bounded
+}
+
+// Описывает липкие сессии через cookie балансировщика
+type Sticky struct {
+	Enabled bool          `yaml:"enabled"` // Включены ли липкие сессии
+	Cookie  string        `yaml:"cookie"`  // Имя cookie
+	Secret  string        `yaml:"secret"`  // Ключ подписи cookie
+	TTL     time.Duration `yaml:"ttl"`     // Время жизни cookie, 0 - до закрытия браузера
+}
+
+// Описывает переключение между группами приоритетов
+type Failover struct {
+	MinHealthy float64 `yaml:"min_healthy"` // Минимальная доля живых серверов в группе, иначе трафик идет в следующую
+}
+
+// Описывает очередь запросов, когда все серверы достигли max_conns
+type Queue struct {
+	Size    int           `yaml:"size"`    // Длина очереди, 0 - без очереди
+	Timeout time.Duration `yaml:"timeout"` // Максимальное время ожидания
+}
+
+// Описывает маршрутизацию с учетом зон доступности
+type Locality struct {
+	Zone       string  `yaml:"zone"`        // Зона, в которой запущен балансировщик
+	MinHealthy float64 `yaml:"min_healthy"` // Минимальная доля живых серверов своей зоны, иначе трафик идет во все зоны
+}
+
+// Описывает DNS-имя, раскрываемое в серверы пула
+type DNSTarget struct {
+	Name     string        `yaml:"name"`      // Префикс имен серверов
+	Host     string        `yaml:"host"`      // Имя с A/AAAA-записями
+	SRV      string        `yaml:"srv"`       // Имя SRV-записи
+	Port     int           `yaml:"port"`      // Порт для A/AAAA-записей
+	Scheme   string        `yaml:"scheme"`    // Схема URL, по умолчанию http
+	Interval time.Duration `yaml:"interval"`  // Период повторного разрешения
+	Weight   int           `yaml:"weight"`    // Вес серверов для A/AAAA-записей
+	MaxConns int64         `yaml:"max_conns"` // Лимит соединений на сервер
+	Zone     string        `yaml:"zone"`      // Зона доступности серверов
+	Upstream string        `yaml:"upstream"`  // Пул, по умолчанию первый
+}
+
+// Описывает файл со списком серверов
+type FileSource struct {
+	Path     string        `yaml:"path"`     // Путь к JSON/YAML-файлу
+	Interval time.Duration `yaml:"interval"` // Период проверки изменений
+	Upstream string        `yaml:"upstream"` // Пул, по умолчанию первый
+}
+
+// Описывает динамическое обнаружение серверов
+type Discovery struct {
+	DNS   []DNSTarget  `yaml:"dns"`   // Серверы из DNS
+	Files []FileSource `yaml:"files"` // Серверы из файлов
+}
+
+// Описывает активную проверку состояния серверов
+type HealthCheck struct {
+	Type           string            `yaml:"type"`            // Тип проверки: http, tcp, grpc; по умолчанию http
+	GRPCService    string            `yaml:"grpc_service"`    // Имя сервиса для grpc.health.v1, пустое - сервер целиком
+	Method         string            `yaml:"method"`          // Метод запроса, по умолчанию HEAD
+	Path           string            `yaml:"path"`            // Путь проверки, например /healthz
+	ExpectedStatus []string          `yaml:"expected_status"` // Допустимые коды: "200", "200-299"; по умолчанию < 500
+	BodyContains   string            `yaml:"body_contains"`   // Подстрока в теле ответа
+	BodyRegex      string            `yaml:"body_regex"`      // Регулярное выражение для тела ответа
+	Headers        map[string]string `yaml:"headers"`         // Заголовки запроса
+	Timeout        time.Duration     `yaml:"timeout"`         // Таймаут проверки, по умолчанию 2s
+	Interval       time.Duration     `yaml:"interval"`        // Интервал проверок, заменяет health_interval
+	Rise           int               `yaml:"rise"`            // Успешных проверок подряд для восстановления, по умолчанию 2
+	Fall           int               `yaml:"fall"`            // Неудачных проверок подряд для исключения, по умолчанию 3
+	MaxBackoff     time.Duration     `yaml:"max_backoff"`     // Максимальный интервал проверок недоступного сервера, 0 - без увеличения
+	History        int               `yaml:"history"`         // Сколько результатов проверок хранить на сервер, по умолчанию 50
+}
+
+// Описывает пассивную проверку серверов по ответам на живой трафик
+type OutlierDetection struct {
+	Enabled                  bool          `yaml:"enabled"`                    // Включена ли проверка
+	Consecutive5xx           int           `yaml:"consecutive_5xx"`            // Ответов 5xx подряд для исключения, по умолчанию 5, -1 - не учитывать
+	ConsecutiveGatewayErrors int           `yaml:"consecutive_gateway_errors"` // Ответов 502/503/504 и ошибок соединения подряд, по умолчанию 5, -1 - не учитывать
+	ErrorRate                float64       `yaml:"error_rate"`                 // Доля ошибок в окне для исключения, 0 - не учитывать
+	MinRequests              int           `yaml:"min_requests"`               // Минимум запросов в окне для проверки доли ошибок
+	Window                   time.Duration `yaml:"window"`                     // Окно подсчета доли ошибок
+	BaseEjection             time.Duration `yaml:"base_ejection"`              // Базовое время исключения
+	MaxEjection              time.Duration `yaml:"max_ejection"`               // Максимальное время исключения
+	MaxEjectedPercent        int           `yaml:"max_ejected_percent"`        // Максимальный процент исключенных серверов
+}
+
+// Описывает автомат защиты серверов в прокси
+type CircuitBreaker struct {
+	Enabled             bool          `yaml:"enabled"`              // Включен ли автомат
+	ConsecutiveFailures int           `yaml:"consecutive_failures"` // Ошибок подряд для размыкания, по умолчанию 5, -1 - не учитывать
+	FailureRatio        float64       `yaml:"failure_ratio"`        // Доля ошибок за окно для размыкания, 0 - не учитывать
+	MinRequests         int           `yaml:"min_requests"`         // Минимум запросов в окне для проверки доли ошибок
+	Window              time.Duration `yaml:"window"`               // Окно подсчета доли ошибок
+	OpenTimeout         time.Duration `yaml:"open_timeout"`         // Сколько автомат остается разомкнутым
+	HalfOpenRequests    int           `yaml:"half_open_requests"`   // Пробных запросов в полуоткрытом состоянии
+}
+
+// Описывает повторные попытки запроса на других серверах
+type Retry struct {
+	Attempts      int           `yaml:"attempts"`        // Максимум попыток, включая первую, по умолчанию 3
+	RetryOn       []string      `yaml:"retry_on"`        // Условия: connect_error, timeout и коды ответа; по умолчанию connect_error
+	NonIdempotent bool          `yaml:"non_idempotent"`  // Повторять POST и PATCH
+	MaxBody       int64         `yaml:"max_body"`        // Максимальный размер тела для повтора в байтах, по умолчанию 64KiB
+	PerTryTimeout time.Duration `yaml:"per_try_timeout"` // Таймаут одной попытки
+	Budget        time.Duration `yaml:"budget"`          // Время, после которого новые попытки не начинаются
+}
+
+// Описывает соединения балансировщика с серверами
+type Transport struct {
+	MaxIdleConns          int           `yaml:"max_idle_conns"`          // Простаивающих соединений на сервер, по умолчанию 256
+	MaxConnsPerHost       int           `yaml:"max_conns_per_host"`      // Максимум соединений на сервер, 0 - без ограничения
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`       // По умолчанию 90s
+	DialTimeout           time.Duration `yaml:"dial_timeout"`            // По умолчанию 30s
+	KeepAlive             time.Duration `yaml:"keep_alive"`              // Период TCP keep-alive, по умолчанию 30s, -1 - выключен
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`   // По умолчанию 10s
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"` // 0 - без таймаута
+	DisableKeepAlives     bool          `yaml:"disable_keep_alives"`     // Новое соединение на каждый запрос
+}
+
+// Описывает дублирование медленных идемпотентных запросов
+type Hedging struct {
+	Enabled    bool          `yaml:"enabled"`    // Включено ли дублирование
+	Delay      time.Duration `yaml:"delay"`      // Через сколько отправлять дубль
+	Percentile float64       `yaml:"percentile"` // Перцентиль наблюдаемой задержки вместо delay, например 95
+	MaxRate    float64       `yaml:"max_rate"`   // Максимальная доля дублированных запросов, по умолчанию 0.1
+}
+
+// Имя пула, который образуют серверы верхнего уровня конфига
+const DefaultUpstream = "default"
+
+// Описывает именованный пул серверов со своим алгоритмом и проверкой
+type Upstream struct {
+	Name        string       `yaml:"name"`
+	Algorithm   string       `yaml:"algorithm"`    // По умолчанию общий algorithm
+	Hash        *Hash        `yaml:"hash"`         // По умолчанию общий hash
+	Backends    []Backend    `yaml:"backends"`     // Серверы пула
+	HealthCheck *HealthCheck `yaml:"health_check"` // По умолчанию общий health_check
+}
+
+// Описывает правило маршрутизации запросов в пул. Заданные условия
+// должны выполняться все, правила проверяются по порядку
+type Route struct {
+	Name       string            `yaml:"name"`
+	Host       string            `yaml:"host"`        // Имя хоста, "*.example.com" - любой поддомен
+	PathPrefix string            `yaml:"path_prefix"` // Префикс пути
+	PathRegex  string            `yaml:"path_regex"`  // Регулярное выражение для пути
+	Methods    []string          `yaml:"methods"`     // Допустимые методы
+	Headers    map[string]string `yaml:"headers"`     // Заголовки, пустое значение - заголовок присутствует
+	Upstream   string            `yaml:"upstream"`    // Пул, в который идут запросы
+	Split      []Split           `yaml:"split"`       // Разделение трафика между пулами вместо upstream
+	StickyBy   *SplitKey         `yaml:"sticky_by"`   // Привязка клиента к варианту split, nil - без привязки
+}
+
+// Доля трафика маршрута, которая идет в пул
+type Split struct {
+	Upstream string `yaml:"upstream"`
+	Weight   int    `yaml:"weight"`
+}
+
+// Ключ клиента для привязки к варианту, как у hash: ip, header, cookie или query
+type SplitKey struct {
+	Key  string `yaml:"key"`
+	Name string `yaml:"name"`
+}
+
+// Параметры API управления. Оно слушает отдельный адрес, чтобы клиенты
+// балансировщика не могли менять серверы и маршруты
+type Admin struct {
+	Addr  string `yaml:"addr"`  // Адрес API управления
+	Token string `yaml:"token"` // Токен Authorization: Bearer, пустой - без проверки
+}
+
 type Config struct {
-	ListenAddr     string        `yaml:"listen_addres"`      // Адрес, на котором слушает HTTP-сервер
-	Algorithm      string        `yaml:"algorithm"`          // Способ балансировки
-	Backends       []Backend     `yaml:"backends"`           // Список серверов
-	DefaultLimit   RateLimit     `yaml:"default_rate_limit"` // Лимиты по умолчанию
-	HealthInterval string        `yaml:"health_interval"`    // Интервал проверки серверов
-	DbDSN          string        // Строка подключения к PostgreSQL
-	healthDur      time.Duration // Интервал для healthcheck
+	ListenAddr     string           `yaml:"listen_addres"`      // Адрес, на котором слушает HTTP-сервер
+	Algorithm      string           `yaml:"algorithm"`          // Способ балансировки
+	Backends       []Backend        `yaml:"backends"`           // Список серверов пула default
+	Upstreams      []Upstream       `yaml:"upstreams"`          // Именованные пулы серверов
+	Routes         []Route          `yaml:"routes"`             // Правила маршрутизации, без совпадения - пул default
+	Hash           Hash             `yaml:"hash"`               // Ключ для hash-алгоритмов
+	Sticky         Sticky           `yaml:"sticky"`             // Липкие сессии
+	Failover       Failover         `yaml:"failover"`           // Группы приоритетов
+	SlowStart      time.Duration    `yaml:"slow_start"`         // Окно прогрева восстановившегося сервера
+	Queue          Queue            `yaml:"queue"`              // Очередь запросов
+	Locality       Locality         `yaml:"locality"`           // Зоны доступности
+	Discovery      Discovery        `yaml:"discovery"`          // Обнаружение серверов
+	DefaultLimit   RateLimit        `yaml:"default_rate_limit"` // Лимиты по умолчанию
+	HealthInterval string           `yaml:"health_interval"`    // Интервал проверки серверов
+	HealthCheck    HealthCheck      `yaml:"health_check"`       // Параметры проверки серверов
+	Outlier        OutlierDetection `yaml:"outlier_detection"`  // Пассивная проверка серверов
+	Breaker        CircuitBreaker   `yaml:"circuit_breaker"`    // Автомат защиты серверов
+	Retry          Retry            `yaml:"retry"`              // Повторные попытки
+	Transport      Transport        `yaml:"transport"`          // Соединения с серверами
+	Hedging        Hedging          `yaml:"hedging"`            // Дублирование запросов
+	Admin          Admin            `yaml:"admin"`              // API управления серверами и маршрутами
+	DbDSN          string           // Строка подключения к PostgreSQL
+	healthDur      time.Duration    // Интервал для healthcheck
 }
 
 // Загрузка конфига из yaml
@@ -46,9 +249,47 @@ func Load(path string) (*Config, error) {
 	if cfg.ListenAddr == "" {
 		cfg.ListenAddr = ":8080"
 	}
+	if cfg.Admin.Addr == "" {
+		cfg.Admin.Addr = "127.0.0.1:8081"
+	}
+	if cfg.Admin.Addr == cfg.ListenAddr {
+		return nil, fmt.Errorf("admin addr must differ from listen_addres")
+	}
 	if cfg.Algorithm == "" {
 		cfg.Algorithm = "round_robin"
 	}
+	if cfg.Sticky.Cookie == "" {
+		cfg.Sticky.Cookie = "lb_backend"
+	}
+	if err := checkBackends(cfg.Backends); err != nil {
+		return nil, err
+	}
+	if cfg.Hash.LoadFactor != 0 && cfg.Hash.LoadFactor < 1 {
+		return nil, fmt.Errorf("hash load_factor must be >= 1, got %v", cfg.Hash.LoadFactor)
+	}
+	if cfg.Failover.MinHealthy == 0 {
+		cfg.Failover.MinHealthy = 0.7
+	}
+	if cfg.Failover.MinHealthy < 0 || cfg.Failover.MinHealthy > 1 {
+		return nil, fmt.Errorf("failover min_healthy must be in [0, 1], got %v", cfg.Failover.MinHealthy)
+	}
+	if cfg.Queue.Size > 0 && cfg.Queue.Timeout == 0 {
+		cfg.Queue.Timeout = time.Second
+	}
+	if env := os.Getenv("LB_ZONE"); env != "" {
+		cfg.Locality.Zone = env
+	}
+	if cfg.Locality.MinHealthy == 0 {
+		cfg.Locality.MinHealthy = 0.5
+	}
+	if cfg.Locality.MinHealthy < 0 || cfg.Locality.MinHealthy > 1 {
+		return nil, fmt.Errorf("locality min_healthy must be in [0, 1], got %v", cfg.Locality.MinHealthy)
+	}
+	for _, t := range cfg.Discovery.DNS {
+		if t.Name == "" {
+			return nil, fmt.Errorf("dns discovery target without name")
+		}
+	}
 	if cfg.HealthInterval == "" {
 		cfg.HealthInterval = "3s"
 	}
@@ -57,6 +298,61 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 	cfg.healthDur = d
+	if err := cfg.HealthCheck.setDefaults(d); err != nil {
+		return nil, err
+	}
+	cfg.healthDur = cfg.HealthCheck.Interval
+	if cfg.Outlier.Consecutive5xx == 0 {
+		cfg.Outlier.Consecutive5xx = 5
+	}
+	if cfg.Outlier.ConsecutiveGatewayErrors == 0 {
+		cfg.Outlier.ConsecutiveGatewayErrors = 5
+	}
+	if cfg.Outlier.ErrorRate < 0 || cfg.Outlier.ErrorRate > 1 {
+		return nil, fmt.Errorf("outlier_detection error_rate must be in [0, 1], got %v", cfg.Outlier.ErrorRate)
+	}
+	if p := cfg.Outlier.MaxEjectedPercent; p < 0 || p > 100 {
+		return nil, fmt.Errorf("outlier_detection max_ejected_percent must be in [0, 100], got %d", p)
+	}
+	if cfg.Breaker.ConsecutiveFailures == 0 {
+		cfg.Breaker.ConsecutiveFailures = 5
+	}
+	if cfg.Breaker.FailureRatio < 0 || cfg.Breaker.FailureRatio > 1 {
+		return nil, fmt.Errorf("circuit_breaker failure_ratio must be in [0, 1], got %v", cfg.Breaker.FailureRatio)
+	}
+	if cfg.Retry.Attempts == 0 {
+		cfg.Retry.Attempts = 3
+	}
+	if len(cfg.Retry.RetryOn) == 0 {
+		cfg.Retry.RetryOn = []string{"connect_error"}
+	}
+	for _, on := range cfg.Retry.RetryOn {
+		if _, err := strconv.Atoi(on); err != nil && on != "connect_error" && on != "timeout" {
+			return nil, fmt.Errorf("unknown retry condition %q", on)
+		}
+	}
+	if cfg.Retry.MaxBody == 0 {
+		cfg.Retry.MaxBody = 64 << 10
+	}
+	if h := cfg.Hedging; h.Enabled && h.Delay <= 0 && h.Percentile <= 0 {
+		return nil, fmt.Errorf("hedging requires delay or percentile")
+	}
+	if p := cfg.Hedging.Percentile; p < 0 || p >= 100 {
+		return nil, fmt.Errorf("hedging percentile must be in [0, 100), got %v", p)
+	}
+	if r := cfg.Hedging.MaxRate; r < 0 || r > 1 {
+		return nil, fmt.Errorf("hedging max_rate must be in [0, 1], got %v", r)
+	}
+	if err := cfg.setUpstreams(); err != nil {
+		return nil, err
+	}
+
+	if env := os.Getenv("STICKY_SECRET"); env != "" {
+		cfg.Sticky.Secret = env
+	}
+	if env := os.Getenv("ADMIN_TOKEN"); env != "" {
+		cfg.Admin.Token = env
+	}
 
 	if env := os.Getenv("DB_DSN"); env != "" {
 		cfg.DbDSN = env
@@ -68,6 +364,169 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// Проверяет параметры серверов и задает значения по умолчанию
+func checkBackends(bs []Backend) error {
+	for i := range bs {
+		b := &bs[i]
+		if b.Weight < 0 {
+			return fmt.Errorf("backend %q: negative weight %d", b.Name, b.Weight)
+		}
+		if b.Weight == 0 {
+			b.Weight = 1
+		}
+		if b.MaxConns < 0 {
+			return fmt.Errorf("backend %q: negative max_conns %d", b.Name, b.MaxConns)
+		}
+		if !validCheck(b.Check) {
+			return fmt.Errorf("backend %q: unknown check type %q", b.Name, b.Check)
+		}
+	}
+	return nil
+}
+
+// Проверяет параметры health check и задает значения по умолчанию,
+// interval - интервал проверок, если он не задан
+func (hc *HealthCheck) setDefaults(interval time.Duration) error {
+	if hc.Interval <= 0 {
+		hc.Interval = interval
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = 2 * time.Second
+	}
+	if hc.Type == "" {
+		hc.Type = "http"
+	}
+	if !validCheck(hc.Type) {
+		return fmt.Errorf("unknown health_check type %q", hc.Type)
+	}
+	if hc.Rise == 0 {
+		hc.Rise = 2
+	}
+	if hc.Fall == 0 {
+		hc.Fall = 3
+	}
+	if hc.Rise < 0 || hc.Fall < 0 {
+		return fmt.Errorf("health_check rise and fall must be positive")
+	}
+	if (hc.BodyContains != "" || hc.BodyRegex != "") && (hc.Method == "" || strings.EqualFold(hc.Method, "HEAD")) {
+		return fmt.Errorf("health_check body match requires method with response body, e.g. GET")
+	}
+	return nil
+}
+
+// Допустимое имя пула
+var upstreamName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Собирает пулы серверов: серверы верхнего уровня образуют пул default,
+// незаданные параметры пулов берутся из общих. Проверяет ссылки маршрутов
+// и обнаружения серверов на пулы
+func (cfg *Config) setUpstreams() error {
+	if len(cfg.Backends) != 0 || len(cfg.Upstreams) == 0 {
+		cfg.Upstreams = append([]Upstream{{Name: DefaultUpstream, Backends: cfg.Backends}}, cfg.Upstreams...)
+	}
+
+	names := map[string]bool{}
+	for i := range cfg.Upstreams {
+		u := &cfg.Upstreams[i]
+		if u.Name == "" {
+			return fmt.Errorf("upstream without name")
+		}
+		// Имя входит в путь API и в имя cookie липкой сессии
+		if !upstreamName.MatchString(u.Name) {
+			return fmt.Errorf("upstream %q: name may contain only letters, digits, '.', '_' and '-'", u.Name)
+		}
+		if names[u.Name] {
+			return fmt.Errorf("duplicate upstream %q", u.Name)
+		}
+		names[u.Name] = true
+
+		if u.Algorithm == "" {
+			u.Algorithm = cfg.Algorithm
+		}
+		if u.Hash == nil {
+			h := cfg.Hash
+			u.Hash = &h
+		}
+		if u.Hash.LoadFactor != 0 && u.Hash.LoadFactor < 1 {
+			return fmt.Errorf("upstream %q: hash load_factor must be >= 1, got %v", u.Name, u.Hash.LoadFactor)
+		}
+		if u.HealthCheck == nil {
+			hc := cfg.HealthCheck
+			u.HealthCheck = &hc
+		}
+		if err := u.HealthCheck.setDefaults(cfg.healthDur); err != nil {
+			return fmt.Errorf("upstream %q: %w", u.Name, err)
+		}
+		if err := checkBackends(u.Backends); err != nil {
+			return fmt.Errorf("upstream %q: %w", u.Name, err)
+		}
+	}
+
+	routes := map[string]bool{}
+	for _, rt := range cfg.Routes {
+		if len(rt.Split) == 0 {
+			if !names[rt.Upstream] {
+				return fmt.Errorf("route %q: unknown upstream %q", rt.Name, rt.Upstream)
+			}
+			continue
+		}
+		// Веса split меняются через API по имени маршрута
+		if rt.Name == "" || routes[rt.Name] {
+			return fmt.Errorf("route with split needs unique name, got %q", rt.Name)
+		}
+		routes[rt.Name] = true
+		if rt.Upstream != "" {
+			return fmt.Errorf("route %q: upstream and split are mutually exclusive", rt.Name)
+		}
+		total, seen := 0, map[string]bool{}
+		for _, sp := range rt.Split {
+			if !names[sp.Upstream] {
+				return fmt.Errorf("route %q: unknown split upstream %q", rt.Name, sp.Upstream)
+			}
+			if seen[sp.Upstream] {
+				return fmt.Errorf("route %q: duplicate split upstream %q", rt.Name, sp.Upstream)
+			}
+			seen[sp.Upstream] = true
+			if sp.Weight < 0 {
+				return fmt.Errorf("route %q: negative split weight %d", rt.Name, sp.Weight)
+			}
+			total += sp.Weight
+		}
+		if total == 0 {
+			return fmt.Errorf("route %q: split weights sum to zero", rt.Name)
+		}
+	}
+	// Обнаруженные серверы по умолчанию попадают в первый пул
+	for i := range cfg.Discovery.DNS {
+		t := &cfg.Discovery.DNS[i]
+		if t.Upstream == "" {
+			t.Upstream = cfg.Upstreams[0].Name
+		}
+		if !names[t.Upstream] {
+			return fmt.Errorf("dns discovery %q: unknown upstream %q", t.Name, t.Upstream)
+		}
+	}
+	for i := range cfg.Discovery.Files {
+		f := &cfg.Discovery.Files[i]
+		if f.Upstream == "" {
+			f.Upstream = cfg.Upstreams[0].Name
+		}
+		if !names[f.Upstream] {
+			return fmt.Errorf("file discovery %q: unknown upstream %q", f.Path, f.Upstream)
+		}
+	}
+	return nil
+}
+
+// Сообщает, поддерживается ли тип проверки, пустой означает тип по умолчанию
+func validCheck(t string) bool {
+	switch t {
+	case "", "http", "tcp", "grpc":
+		return true
+	}
+	return false
+}
+
 // GetDSN возвращает строку подключения к бд
 func (c *Config) GetDSN() string { return c.DbDSN }
 
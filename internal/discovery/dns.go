@@ -0,0 +1,157 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+	"loadbalancer/internal/logging"
+)
+
+// Resolver выполняет DNS-запросы, ему соответствует *net.Resolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Target описывает DNS-имя, которое раскрывается в серверы пула
+type Target struct {
+	Name     string            // Префикс имен серверов в пуле
+	Host     string            // Имя для A/AAAA-записей
+	SRV      string            // Имя SRV-записи, например _http._tcp.api.internal
+	Port     int               // Порт для A/AAAA-записей
+	Scheme   string            // Схема URL серверов, по умолчанию http
+	Interval time.Duration     // Период повторного разрешения
+	Spec     loadbalancer.Spec // Параметры создаваемых серверов
+}
+
+// DNS периодически разрешает имена и синхронизирует с ними серверы пула
+type DNS struct {
+	members  *members
+	target   Target
+	resolver Resolver
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Создает DNS-discovery для одного target
+func NewDNS(pool *loadbalancer.Pool, t Target, r Resolver) (*DNS, error) {
+	if (t.Host == "") == (t.SRV == "") {
+		return nil, fmt.Errorf("discovery %q: exactly one of host or srv must be set", t.Name)
+	}
+	if t.Host != "" && t.Port == 0 {
+		return nil, fmt.Errorf("discovery %q: port is required for host", t.Name)
+	}
+	if t.Scheme == "" {
+		t.Scheme = "http"
+	}
+	if t.Interval <= 0 {
+		t.Interval = 30 * time.Second
+	}
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return &DNS{
+		members:  newMembers(pool),
+		target:   t,
+		resolver: r,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Разрешает имя сразу, а затем периодически в отдельной горутине
+func (d *DNS) Start() {
+	if err := d.Sync(context.Background(), false); err != nil {
+		logging.L.Error("dns discovery failed", "target", d.target.Name, "error", err)
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.target.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := d.Sync(context.Background(), true); err != nil {
+					logging.L.Error("dns discovery failed", "target", d.target.Name, "error", err)
+				}
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+	logging.L.Info("dns discovery started", "target", d.target.Name, "interval", d.target.Interval)
+}
+
+// Останавливает периодическое разрешение
+func (d *DNS) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// Разрешает имя и приводит серверы пула в соответствие с записями.
+// При ошибке DNS пул не меняется. Новые серверы проходят прогрев, если ramp = true
+func (d *DNS) Sync(ctx context.Context, ramp bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	specs, err := d.resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	added, removed, err := d.members.apply(specs, ramp)
+	if err != nil {
+		return err
+	}
+	if added != 0 || removed != 0 {
+		logging.L.Info("dns discovery updated pool", "target", d.target.Name, "added", added, "removed", removed)
+	}
+	return nil
+}
+
+// Возвращает параметры серверов по текущим DNS-записям
+func (d *DNS) resolve(ctx context.Context) (map[string]loadbalancer.Spec, error) {
+	t := d.target
+	out := make(map[string]loadbalancer.Spec)
+
+	if t.SRV != "" {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", t.SRV)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			host := strings.TrimSuffix(rec.Target, ".")
+			s := d.spec(net.JoinHostPort(host, strconv.Itoa(int(rec.Port))))
+			// Приоритет SRV - группа приоритета, вес SRV - вес сервера
+			s.Priority = int(rec.Priority)
+			s.Weight = max(int(rec.Weight), 1)
+			out[s.Name] = s
+		}
+		return out, nil
+	}
+
+	addrs, err := d.resolver.LookupIPAddr(ctx, t.Host)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		s := d.spec(net.JoinHostPort(a.IP.String(), strconv.Itoa(t.Port)))
+		out[s.Name] = s
+	}
+	return out, nil
+}
+
+// Параметры сервера для адреса host:port
+func (d *DNS) spec(hostport string) loadbalancer.Spec {
+	s := d.target.Spec
+	s.Name = d.target.Name + "@" + hostport
+	s.URL = (&url.URL{Scheme: d.target.Scheme, Host: hostport}).String()
+	return s
+}
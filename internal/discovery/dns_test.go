@@ -0,0 +1,107 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// Отвечает заранее заданными записями
+type stubResolver struct {
+	ips []string
+	srv []*net.SRV
+}
+
+func (s *stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var out []net.IPAddr
+	for _, ip := range s.ips {
+		out = append(out, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return out, nil
+}
+
+func (s *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "", s.srv, nil
+}
+
+func names(p *loadbalancer.Pool) []string {
+	var out []string
+	for _, b := range p.List() {
+		out = append(out, b.Name())
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestDNS_A(t *testing.T) {
+	static, _ := loadbalancer.NewBackend("http://static")
+	pool, _ := loadbalancer.NewPool([]loadbalancer.Backend{static}, loadbalancer.NewRoundRobin)
+	r := &stubResolver{ips: []string{"10.0.0.1", "10.0.0.2"}}
+	d, err := NewDNS(pool, Target{Name: "api", Host: "api.internal", Port: 8080}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Sync(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"api@10.0.0.1:8080", "api@10.0.0.2:8080", "http://static"}
+	if got := names(pool); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	kept := pool.Get("api@10.0.0.2:8080")
+	kept.SetAlive(false)
+
+	// Один адрес пропал, появился новый, состояние оставшегося сохраняется
+	r.ips = []string{"10.0.0.2", "fd00::3"}
+	if err := d.Sync(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"api@10.0.0.2:8080", "api@[fd00::3]:8080", "http://static"}
+	if got := names(pool); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if b := pool.Get("api@10.0.0.2:8080"); b != kept || b.Alive() {
+		t.Error("existing backend was recreated")
+	}
+	if u := pool.Get("api@[fd00::3]:8080").URL().String(); u != "http://[fd00::3]:8080" {
+		t.Errorf("unexpected url %s", u)
+	}
+}
+
+func TestDNS_SRV(t *testing.T) {
+	pool, _ := loadbalancer.NewPool(nil, loadbalancer.NewRoundRobin)
+	r := &stubResolver{srv: []*net.SRV{
+		{Target: "a.internal.", Port: 80, Priority: 0, Weight: 10},
+		{Target: "b.internal.", Port: 80, Priority: 1, Weight: 0},
+	}}
+	d, _ := NewDNS(pool, Target{Name: "svc", SRV: "_http._tcp.svc.internal"}, r)
+	if err := d.Sync(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	a := pool.Get("svc@a.internal:80")
+	b := pool.Get("svc@b.internal:80")
+	if a == nil || b == nil {
+		t.Fatalf("unexpected pool: %v", names(pool))
+	}
+	if a.Weight() != 10 || a.Priority() != 0 || b.Weight() != 1 || b.Priority() != 1 {
+		t.Errorf("srv attributes not mapped: a=%d/%d b=%d/%d", a.Weight(), a.Priority(), b.Weight(), b.Priority())
+	}
+
+	// Вес и приоритет меняются на месте
+	r.srv[0].Weight = 20
+	r.srv[1].Priority = 0
+	if err := d.Sync(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := pool.Get("svc@a.internal:80"); got != a || got.Weight() != 20 {
+		t.Errorf("weight not updated in place: %d", got.Weight())
+	}
+	if got := pool.Get("svc@b.internal:80"); got != b || got.Priority() != 0 {
+		t.Error("priority not updated in place")
+	}
+}
@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+	"loadbalancer/internal/logging"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Сервер в файле обнаружения
+type fileEntry struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Weight   int    `yaml:"weight"`
+	Priority int    `yaml:"priority"`
+	MaxConns int64  `yaml:"max_conns"`
+	Zone     string `yaml:"zone"`
+	Draining bool   `yaml:"draining"`
+}
+
+// File загружает серверы пула из JSON/YAML-файла и следит за его изменениями.
+// Новое содержимое применяется целиком, а некорректный файл игнорируется
+type File struct {
+	members  *members
+	path     string
+	interval time.Duration     // Период проверки файла
+	defaults loadbalancer.Spec // Параметры по умолчанию для серверов из файла
+	sum      [sha256.Size]byte // Хеш последнего примененного содержимого
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Создает обнаружение серверов из файла
+func NewFile(pool *loadbalancer.Pool, path string, interval time.Duration, defaults loadbalancer.Spec) *File {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &File{
+		members:  newMembers(pool),
+		path:     path,
+		interval: interval,
+		defaults: defaults,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Загружает файл сразу, а затем проверяет изменения в отдельной горутине
+func (f *File) Start() error {
+	if err := f.Load(false); err != nil {
+		return err
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := f.Load(true); err != nil {
+					logging.L.Error("file discovery failed", "path", f.path, "error", err)
+				}
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+	logging.L.Info("file discovery started", "path", f.path, "interval", f.interval)
+	return nil
+}
+
+// Останавливает слежение за файлом
+func (f *File) Stop() {
+	close(f.stop)
+	f.wg.Wait()
+}
+
+// Читает файл и, если содержимое изменилось, применяет его к пулу.
+// Новые серверы проходят прогрев, если ramp = true
+func (f *File) Load(ramp bool) error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if sum == f.sum {
+		return nil
+	}
+
+	specs, err := f.parse(data)
+	if err != nil {
+		return err
+	}
+	added, removed, err := f.members.apply(specs, ramp)
+	if err != nil {
+		return err
+	}
+	f.sum = sum
+	logging.L.Info("file discovery applied", "path", f.path, "backends", len(specs), "added", added, "removed", removed)
+	return nil
+}
+
+// Разбирает список серверов. YAML-парсер читает и JSON
+func (f *File) parse(data []byte) (map[string]loadbalancer.Spec, error) {
+	var entries []fileEntry
+	if len(bytes.TrimSpace(data)) != 0 {
+		if err := yaml.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+	}
+
+	specs := make(map[string]loadbalancer.Spec, len(entries))
+	for _, e := range entries {
+		if e.Name == "" || e.URL == "" {
+			return nil, fmt.Errorf("backend without name or url")
+		}
+		if _, ok := specs[e.Name]; ok {
+			return nil, fmt.Errorf("duplicate backend %q", e.Name)
+		}
+		if e.Weight < 0 || e.MaxConns < 0 {
+			return nil, fmt.Errorf("backend %q: negative weight or max_conns", e.Name)
+		}
+		s := f.defaults
+		s.Name = e.Name
+		s.URL = e.URL
+		s.Weight = max(e.Weight, 1)
+		s.Priority = e.Priority
+		s.MaxConns = e.MaxConns
+		s.Zone = e.Zone
+		s.Draining = e.Draining
+		specs[e.Name] = s
+	}
+	return specs, nil
+}
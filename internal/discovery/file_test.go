@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+func TestFile_Load(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool, _ := loadbalancer.NewPool(nil, loadbalancer.NewRoundRobin)
+	f := NewFile(pool, path, 0, loadbalancer.Spec{})
+
+	write(`
+- name: a
+  url: http://10.0.0.1
+- name: b
+  url: http://10.0.0.2
+  weight: 3
+`)
+	if err := f.Load(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(pool); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("got %v", got)
+	}
+	a := pool.Get("a")
+	a.SetAlive(false)
+	a.Acquire()
+
+	// JSON тоже поддерживается; a остается со своим состоянием, b удален, c добавлен
+	write(`[{"name": "a", "url": "http://10.0.0.1", "weight": 2, "zone": "z1"},
+	        {"name": "c", "url": "http://10.0.0.3"}]`)
+	if err := f.Load(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(pool); !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("got %v", got)
+	}
+	if b := pool.Get("a"); b != a || b.Alive() || b.Conns() != 1 || b.Weight() != 2 || b.Zone() != "z1" {
+		t.Error("state of kept backend wasn't preserved")
+	}
+
+	// Некорректный файл не меняет пул
+	write(`[{"name": "a", "url": "http://10.0.0.1"}, {"name": "a", "url": "http://10.0.0.9"}]`)
+	if err := f.Load(true); err == nil {
+		t.Error("expected error for duplicate names")
+	}
+	if got := names(pool); !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("pool changed by invalid file: %v", got)
+	}
+
+	// Сервер удален через API: источник продолжает работать и создает его заново
+	if _, err := pool.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	write(`[{"name": "a", "url": "http://10.0.0.1"}, {"name": "c", "url": "http://10.0.0.3"}]`)
+	if err := f.Load(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(pool); !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("got %v after removal through API", got)
+	}
+	if _, err := pool.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	write(`[{"name": "a", "url": "http://10.0.0.1"}]`)
+	if err := f.Load(true); err != nil {
+		t.Errorf("sync failed after removal through API: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"slices"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// members - серверы пула, которыми управляет один источник обнаружения
+type members struct {
+	pool  *loadbalancer.Pool
+	owned map[string]loadbalancer.Spec // Имя сервера - параметры, с которыми он создан
+}
+
+func newMembers(pool *loadbalancer.Pool) *members {
+	return &members{pool: pool, owned: make(map[string]loadbalancer.Spec)}
+}
+
+// Приводит серверы источника в соответствие со specs одной операцией над пулом.
+// Серверы с тем же именем и URL обновляются на месте и сохраняют соединения
+// и состояние health check. Новые серверы проходят прогрев, если ramp = true
+func (m *members) apply(specs map[string]loadbalancer.Spec, ramp bool) (added, removed int, err error) {
+	var add []loadbalancer.Backend
+	var remove []string
+	rebuild := false
+
+	// Сервер мог быть удален через API, тогда источник им больше не владеет
+	// и при наличии записи создает его заново
+	for name := range m.owned {
+		if m.pool.Get(name) == nil {
+			delete(m.owned, name)
+		}
+	}
+	for name, old := range m.owned {
+		if s, ok := specs[name]; !ok || s.URL != old.URL {
+			remove = append(remove, name)
+		}
+	}
+	for name, s := range specs {
+		old, ok := m.owned[name]
+		if ok && old.URL == s.URL {
+			if b := m.pool.Get(name); b != nil && old != s {
+				rebuild = update(b, old, s) || rebuild
+			}
+			continue
+		}
+		b, err := loadbalancer.NewFromSpec(s)
+		if err != nil {
+			return 0, 0, err
+		}
+		if ramp {
+			b.StartRamp()
+		}
+		add = append(add, b)
+	}
+	slices.Sort(remove)
+
+	switch {
+	case len(add) != 0 || len(remove) != 0:
+		if err := m.pool.Update(add, remove); err != nil {
+			return 0, 0, err
+		}
+	case rebuild:
+		m.pool.Rebuild()
+	}
+	m.owned = specs
+	return len(add), len(remove), nil
+}
+
+// Применяет изменившиеся параметры к серверу. Возвращает true,
+// если изменились приоритет или зона и селектор нужно пересоздать
+func update(b loadbalancer.Backend, old, cur loadbalancer.Spec) bool {
+	if old.Weight != cur.Weight {
+		b.SetWeight(cur.Weight)
+	}
+	if old.MaxConns != cur.MaxConns {
+		b.SetMaxConns(cur.MaxConns)
+	}
+	if old.Draining != cur.Draining {
+		b.SetDraining(cur.Draining)
+	}
+	rebuild := false
+	if old.Priority != cur.Priority {
+		b.SetPriority(cur.Priority)
+		rebuild = true
+	}
+	if old.Zone != cur.Zone {
+		b.SetZone(cur.Zone)
+		rebuild = true
+	}
+	return rebuild
+}
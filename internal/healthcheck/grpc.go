@@ -0,0 +1,85 @@
+package healthcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// GRPCProbe проверяет сервер по протоколу grpc.health.v1. Для схем https
+// и grpcs соединение устанавливается через TLS
+type GRPCProbe struct {
+	Service string // Имя сервиса, пустое - состояние сервера целиком
+}
+
+// Probe вызывает Health/Check и ожидает статус SERVING. Checker не
+// вызывает Probe, а держит одно соединение на сервер и передает его в check
+func (p GRPCProbe) Probe(ctx context.Context, b loadbalancer.Backend) error {
+	conn, err := p.dial(b)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return p.check(ctx, conn)
+}
+
+// Создает соединение с сервером. Подключение происходит лениво при первом вызове
+func (p GRPCProbe) dial(b loadbalancer.Backend) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if s := b.URL().Scheme; s == "https" || s == "grpcs" {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	return grpc.NewClient(hostPort(b), grpc.WithTransportCredentials(creds))
+}
+
+// Вызывает Health/Check через готовое соединение
+func (p GRPCProbe) check(ctx context.Context, conn *grpc.ClientConn) error {
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: p.Service})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("grpc health status %s", resp.GetStatus())
+	}
+	return nil
+}
+
+// Выполняет gRPC-проверку через соединение сервера из состояния проверок.
+// Соединение создается при первой проверке и закрывается, когда сервер
+// уходит из пула
+func (c *Checker) probeGRPC(ctx context.Context, p GRPCProbe, b loadbalancer.Backend) error {
+	c.smu.Lock()
+	st, ok := c.states[b]
+	var conn *grpc.ClientConn
+	if ok {
+		conn = st.conn
+	}
+	c.smu.Unlock()
+	if !ok {
+		// Сервер уже удален из пула, соединение не сохраняем
+		return p.Probe(ctx, b)
+	}
+
+	if conn == nil {
+		var err error
+		if conn, err = p.dial(b); err != nil {
+			return err
+		}
+		c.smu.Lock()
+		if cur, ok := c.states[b]; ok && cur == st {
+			st.conn = conn
+			c.smu.Unlock()
+		} else {
+			c.smu.Unlock()
+			defer conn.Close()
+		}
+	}
+	return p.check(ctx, conn)
+}
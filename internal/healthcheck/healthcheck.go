@@ -1,25 +1,192 @@
 package healthcheck
 
 import (
-	"net/http"
+	"context"
 	"sync"
 	"time"
 
+	"google.golang.org/grpc"
+
 	"loadbalancer/internal/loadbalancer"
 	"loadbalancer/internal/logging"
 )
 
 // Класс, отвечающий за проверку состояния бэкендов
 type Checker struct {
-	backends []loadbalancer.Backend // Список проверяемых серверов
-	interval time.Duration          // Частота проверок
-	stop     chan struct{}          // Сигнал остановки
-	wg       sync.WaitGroup         // Ожидание завершения горутин
+	mu       sync.RWMutex
+	backends []loadbalancer.Backend          // Список проверяемых серверов
+	interval time.Duration                   // Частота проверок
+	timeout  time.Duration                   // Таймаут одной проверки
+	probe    Prober                          // Проверка по умолчанию
+	probes   map[string]Prober               // Проверки отдельных серверов по имени
+	rise     int                             // Сколько успешных проверок подряд нужно для восстановления
+	fall     int                             // Сколько неудачных проверок подряд нужно для исключения
+	backoff  time.Duration                   // Максимальный интервал проверок недоступного сервера
+	hold     func(loadbalancer.Backend) bool // Запрещает восстанавливать сервер, nil - без ограничений
+
+	historySize int // Сколько результатов проверок хранить на сервер
+
+	smu    sync.Mutex
+	states map[loadbalancer.Backend]*state // Состояние проверок по серверам
+	subs   map[chan Event]struct{}         // Подписчики на изменения статуса
+
+	stop chan struct{}  // Сигнал остановки
+	wg   sync.WaitGroup // Ожидание завершения горутин
+}
+
+// Состояние проверок одного сервера
+type state struct {
+	successes int       // Успешных проверок подряд
+	failures  int       // Неудачных проверок подряд
+	flaps     int       // Сколько раз менялся статус сервера
+	next      time.Time // Время следующей проверки недоступного сервера
+	running   bool      // Проверка уже выполняется
+	history   history   // Последние результаты проверок
+
+	conn *grpc.ClientConn // Соединение gRPC-проверки, nil - еще не создано
+}
+
+// Prober проверяет доступность одного сервера
+type Prober interface {
+	Probe(ctx context.Context, b loadbalancer.Backend) error
+}
+
+// Дополнительная настройка Checker
+type Option func(*Checker)
+
+// Задает проверку по умолчанию. Без нее отправляется HEAD на корень
+// сервера, успешным считается любой код < 500
+func WithProbe(p Prober) Option {
+	return func(c *Checker) {
+		if p != nil {
+			c.probe = p
+		}
+	}
+}
+
+// Задает проверки для отдельных серверов по имени, остальные
+// проверяются проверкой по умолчанию
+func WithBackendProbes(probes map[string]Prober) Option {
+	return func(c *Checker) {
+		c.probes = probes
+	}
+}
+
+// Задает таймаут одной проверки, по умолчанию 2s
+func WithTimeout(d time.Duration) Option {
+	return func(c *Checker) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// Задает пороги: сервер восстанавливается после rise успешных проверок подряд
+// и исключается после fall неудачных. По умолчанию оба равны 1
+func WithThresholds(rise, fall int) Option {
+	return func(c *Checker) {
+		if rise > 0 {
+			c.rise = rise
+		}
+		if fall > 0 {
+			c.fall = fall
+		}
+	}
+}
+
+// Включает экспоненциальное увеличение интервала проверок недоступного
+// сервера: интервал удваивается после каждой неудачи, но не больше max
+func WithBackoff(max time.Duration) Option {
+	return func(c *Checker) {
+		c.backoff = max
+	}
+}
+
+// Задает условие, при котором успешно проверенный сервер не возвращается
+// в работу, например пока он исключен пассивной проверкой
+func WithHold(hold func(loadbalancer.Backend) bool) Option {
+	return func(c *Checker) {
+		c.hold = hold
+	}
 }
 
 // Создание нового healthchecker
-func New(bs []loadbalancer.Backend, d time.Duration) *Checker {
-	return &Checker{backends: bs, interval: d, stop: make(chan struct{})}
+func New(bs []loadbalancer.Backend, d time.Duration, opts ...Option) *Checker {
+	c := &Checker{
+		backends: bs,
+		interval: d,
+		timeout:  2 * time.Second,
+		probe:    &HTTPProbe{},
+		rise:     1,
+		fall:     1,
+		states:   map[loadbalancer.Backend]*state{},
+		subs:     map[chan Event]struct{}{},
+
+		historySize: defaultHistory,
+		stop:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Заменяет список проверяемых серверов, используется при изменении пула
+func (c *Checker) SetBackends(bs []loadbalancer.Backend) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backends = bs
+
+	// Удаляем состояние серверов, которых больше нет в пуле
+	keep := make(map[loadbalancer.Backend]bool, len(bs))
+	for _, b := range bs {
+		keep[b] = true
+	}
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	for b, st := range c.states {
+		if !keep[b] {
+			st.close()
+			delete(c.states, b)
+		}
+	}
+}
+
+// Закрывает соединение проверок сервера, если оно есть
+func (st *state) close() {
+	if st.conn != nil {
+		_ = st.conn.Close()
+		st.conn = nil
+	}
+}
+
+// Возвращает состояние проверок сервера. Вызывается под smu
+func (c *Checker) state(b loadbalancer.Backend) *state {
+	st, ok := c.states[b]
+	if !ok {
+		st = &state{}
+		c.states[b] = st
+	}
+	return st
+}
+
+// Сообщает, пора ли проверять сервер, и отмечает начало проверки
+func (c *Checker) due(b loadbalancer.Backend, now time.Time) bool {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	st := c.state(b)
+	if st.running || now.Before(st.next) {
+		return false
+	}
+	st.running = true
+	return true
+}
+
+// Возвращает текущий список проверяемых серверов
+func (c *Checker) list() []loadbalancer.Backend {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.backends
 }
 
 // Запуск цикл проверок в отдельной горутине
@@ -32,8 +199,11 @@ func (c *Checker) Start() {
 			select {
 			case <-ticker.C:
 				// Проверяем каждый backend параллельно
-				for _, b := range c.backends {
-					go c.check(b)
+				now := time.Now()
+				for _, b := range c.list() {
+					if c.due(b, now) {
+						go c.check(b)
+					}
 				}
 			case <-c.stop:
 				ticker.Stop()
@@ -48,24 +218,78 @@ func (c *Checker) Start() {
 func (c *Checker) Stop() {
 	close(c.stop)
 	c.wg.Wait()
+	c.smu.Lock()
+	for _, st := range c.states {
+		st.close()
+	}
+	c.smu.Unlock()
 	logging.L.Info("healthchecker stopped")
 }
 
-// Проверяет доступность одного сервера через HEAD-запрос
+// Проверяет доступность одного сервера. Статус меняется только после
+// rise успешных или fall неудачных проверок подряд
 func (c *Checker) check(b loadbalancer.Backend) {
-	client := http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Head(b.URL().String())
-
-	// Считаем alive, если нет ошибки
-	alive := err == nil && resp.StatusCode < 500
-
-	// Если статус изменился, то обновляем
-	if alive != b.Alive() {
-		b.SetAlive(alive)
-		if alive {
-			logging.L.Info("backend recovered", "backend", b.URL().String())
-		} else {
-			logging.L.Warn("backend down", "backend", b.URL().String())
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	probe, ok := c.probes[b.Name()]
+	if !ok {
+		probe = c.probe
+	}
+	start := time.Now()
+	var err error
+	if p, ok := probe.(GRPCProbe); ok {
+		err = c.probeGRPC(ctx, p, b)
+	} else {
+		err = probe.Probe(ctx, b)
+	}
+	latency := time.Since(start)
+
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	st := c.state(b)
+	st.running = false
+	defer func() {
+		r := Result{Time: start, Latency: latency, Healthy: err == nil, Alive: b.Alive()}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		st.history.add(r, c.historySize)
+	}()
+
+	alive := b.Alive()
+	if err == nil {
+		st.successes++
+		st.failures = 0
+		st.next = time.Time{}
+		if !alive && st.successes >= c.rise && (c.hold == nil || !c.hold(b)) {
+			st.flaps++
+			b.SetAlive(true)
+			c.publish(b, true, st.flaps, nil)
+			logging.L.Info("backend recovered", "backend", b.URL().String(), "flaps", st.flaps)
 		}
+		return
+	}
+
+	st.failures++
+	st.successes = 0
+	if alive && st.failures >= c.fall {
+		st.flaps++
+		alive = false
+		b.SetAlive(false)
+		c.publish(b, false, st.flaps, err)
+		logging.L.Warn("backend down", "backend", b.URL().String(), "error", err, "flaps", st.flaps)
+	}
+	if !alive && c.backoff > c.interval {
+		st.next = time.Now().Add(c.delay(st.failures))
+	}
+}
+
+// Возвращает интервал до следующей проверки недоступного сервера:
+// удваивается с каждой неудачей подряд, но не больше backoff
+func (c *Checker) delay(failures int) time.Duration {
+	d := c.interval
+	for i := 1; i < failures && d < c.backoff; i++ {
+		d *= 2
 	}
+	return min(d, c.backoff)
 }
@@ -3,6 +3,8 @@ package healthcheck
 import (
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -52,3 +54,199 @@ func TestChecker_StartStop(t *testing.T) {
 		t.Errorf("expected 2+ checks, got %d", count)
 	}
 }
+
+func TestChecker_HTTPProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || r.Method != http.MethodGet || r.Header.Get("X-Check") != "1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Host == "degraded.local" {
+			_, _ = w.Write([]byte(`{"status":"degraded"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"up"}`))
+	}))
+	defer srv.Close()
+
+	statuses, err := ParseStatusRanges([]string{"200-299"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &HTTPProbe{
+		Method:    http.MethodGet,
+		Path:      "/healthz",
+		Headers:   http.Header{"X-Check": {"1"}},
+		Statuses:  statuses,
+		BodyRegex: regexp.MustCompile(`"status":\s*"up"`),
+	}
+	b, _ := loadbalancer.NewBackend(srv.URL)
+	c := New([]loadbalancer.Backend{b}, time.Second, WithProbe(probe), WithTimeout(time.Second))
+
+	b.SetAlive(false)
+	c.check(b)
+	if !b.Alive() {
+		t.Error("expected healthy backend")
+	}
+
+	// Тело ответа не совпадает
+	probe.Headers.Set("Host", "degraded.local")
+	c.check(b)
+	if b.Alive() {
+		t.Error("expected backend down on body mismatch")
+	}
+	probe.Headers.Del("Host")
+
+	// Код 404 не входит в допустимые
+	probe.Path = "/missing"
+	b.SetAlive(true)
+	c.check(b)
+	if b.Alive() {
+		t.Error("expected backend down on unexpected status")
+	}
+}
+
+func TestParseStatusRanges(t *testing.T) {
+	rs, err := ParseStatusRanges([]string{"200", "300-399"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0] != (StatusRange{200, 200}) || rs[1] != (StatusRange{300, 399}) {
+		t.Errorf("unexpected ranges %v", rs)
+	}
+	for _, bad := range []string{"abc", "300-200", "2xx"} {
+		if _, err := ParseStatusRanges([]string{bad}); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestChecker_Thresholds(t *testing.T) {
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	b, _ := loadbalancer.NewBackend(srv.URL)
+	c := New([]loadbalancer.Backend{b}, time.Second, WithThresholds(2, 3))
+
+	// Две неудачи не исключают сервер, третья исключает
+	c.check(b)
+	c.check(b)
+	if !b.Alive() {
+		t.Fatal("backend excluded before fall threshold")
+	}
+	c.check(b)
+	if b.Alive() {
+		t.Fatal("expected backend down after 3 failures")
+	}
+
+	// Одна успешная проверка не восстанавливает сервер, вторая восстанавливает
+	healthy.Store(true)
+	c.check(b)
+	if b.Alive() {
+		t.Fatal("backend recovered before rise threshold")
+	}
+	c.check(b)
+	if !b.Alive() {
+		t.Fatal("expected backend recovered after 2 successes")
+	}
+	if flaps := c.states[b].flaps; flaps != 2 {
+		t.Errorf("expected 2 flaps, got %d", flaps)
+	}
+}
+
+func TestChecker_Backoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	b, _ := loadbalancer.NewBackend(srv.URL)
+	c := New([]loadbalancer.Backend{b}, time.Second, WithBackoff(5*time.Second))
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		start := time.Now()
+		if !c.due(b, start) {
+			t.Fatal("expected check to be due")
+		}
+		c.check(b)
+		if got := c.states[b].next.Sub(start); got < want || got > want+time.Second/2 {
+			t.Errorf("expected next check in %v, got %v", want, got)
+		}
+		if c.due(b, start) {
+			t.Error("dead backend checked before backoff expired")
+		}
+		c.states[b].next = time.Time{}
+	}
+}
+
+func TestChecker_Hold(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	b, _ := loadbalancer.NewBackend(srv.URL)
+	b.SetAlive(false)
+	var held atomic.Bool
+	held.Store(true)
+	c := New([]loadbalancer.Backend{b}, time.Second, WithHold(func(loadbalancer.Backend) bool { return held.Load() }))
+
+	c.check(b)
+	if b.Alive() {
+		t.Fatal("held backend must not recover")
+	}
+	held.Store(false)
+	c.check(b)
+	if !b.Alive() {
+		t.Error("expected backend recovered after hold released")
+	}
+}
+
+func TestChecker_HistoryAndEvents(t *testing.T) {
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	b, _ := loadbalancer.NewBackend(srv.URL)
+	c := New([]loadbalancer.Backend{b}, time.Second, WithHistory(3))
+	events, cancel := c.Subscribe()
+	defer cancel()
+
+	c.check(b) // Сервер исключается
+	healthy.Store(true)
+	for i := 0; i != 3; i++ {
+		c.check(b)
+	}
+
+	// Хранятся только три последних результата
+	h := c.History(b.Name())
+	if len(h) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(h))
+	}
+	for _, r := range h {
+		if !r.Healthy || !r.Alive || r.Error != "" {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+
+	for _, alive := range []bool{false, true} {
+		select {
+		case e := <-events:
+			if e.Alive != alive || e.Backend != b.Name() {
+				t.Errorf("unexpected event %+v", e)
+			}
+		default:
+			t.Fatal("expected health event")
+		}
+	}
+	if c.History("missing") != nil {
+		t.Error("expected no history for unknown backend")
+	}
+}
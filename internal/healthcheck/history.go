@@ -0,0 +1,105 @@
+package healthcheck
+
+import (
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// Сколько результатов проверок хранится на сервер по умолчанию
+const defaultHistory = 50
+
+// Результат одной проверки сервера
+type Result struct {
+	Time    time.Time
+	Latency time.Duration
+	Healthy bool   // Проверка прошла успешно
+	Alive   bool   // Статус сервера после проверки
+	Error   string // Причина неудачи
+}
+
+// Изменение статуса сервера по результатам проверок
+type Event struct {
+	Time    time.Time `json:"time"`
+	Backend string    `json:"backend"`
+	URL     string    `json:"url"`
+	Alive   bool      `json:"alive"`
+	Flaps   int       `json:"flaps"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Кольцевой буфер последних результатов проверок
+type history struct {
+	buf  []Result
+	next int  // Куда записывается следующий результат
+	full bool // Буфер заполнен хотя бы раз
+}
+
+// Добавляет результат, вытесняя самый старый
+func (h *history) add(r Result, size int) {
+	if h.buf == nil {
+		h.buf = make([]Result, size)
+	}
+	h.buf[h.next] = r
+	h.next = (h.next + 1) % len(h.buf)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Возвращает копию результатов от старых к новым
+func (h *history) list() []Result {
+	if !h.full {
+		return append([]Result(nil), h.buf[:h.next]...)
+	}
+	return append(append([]Result(nil), h.buf[h.next:]...), h.buf[:h.next]...)
+}
+
+// Задает, сколько последних результатов проверок хранить на сервер
+func WithHistory(size int) Option {
+	return func(c *Checker) {
+		if size > 0 {
+			c.historySize = size
+		}
+	}
+}
+
+// History возвращает последние результаты проверок сервера от старых к новым
+func (c *Checker) History(name string) []Result {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	for b, st := range c.states {
+		if b.Name() == name {
+			return st.history.list()
+		}
+	}
+	return nil
+}
+
+// Subscribe возвращает канал изменений статуса серверов и функцию отписки.
+// Если подписчик не успевает читать, события для него отбрасываются
+func (c *Checker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 16)
+	c.smu.Lock()
+	c.subs[ch] = struct{}{}
+	c.smu.Unlock()
+	return ch, func() {
+		c.smu.Lock()
+		delete(c.subs, ch)
+		c.smu.Unlock()
+	}
+}
+
+// Рассылает событие подписчикам. Вызывается под smu
+func (c *Checker) publish(b loadbalancer.Backend, alive bool, flaps int, err error) {
+	e := Event{Time: time.Now(), Backend: b.Name(), URL: b.URL().String(), Alive: alive, Flaps: flaps}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	for ch := range c.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
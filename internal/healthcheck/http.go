@@ -0,0 +1,118 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// Сколько байт тела ответа читается для проверки содержимого
+const maxBodyCheck = 64 << 10
+
+// Клиент проверок. Перенаправления не выполняются, проверяется код самого сервера
+var probeClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// Диапазон допустимых кодов ответа, включительно
+type StatusRange struct {
+	From, To int
+}
+
+// Разбирает коды вида "200", "200-299"
+func ParseStatusRanges(ss []string) ([]StatusRange, error) {
+	out := make([]StatusRange, 0, len(ss))
+	for _, s := range ss {
+		from, to, isRange := strings.Cut(s, "-")
+		lo, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", s)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid status range %q", s)
+			}
+		}
+		out = append(out, StatusRange{From: lo, To: hi})
+	}
+	return out, nil
+}
+
+// HTTPProbe проверяет сервер HTTP-запросом
+type HTTPProbe struct {
+	Method       string         // Метод запроса, по умолчанию HEAD
+	Path         string         // Путь относительно URL сервера
+	Headers      http.Header    // Дополнительные заголовки, Host задает имя хоста
+	Statuses     []StatusRange  // Допустимые коды ответа, по умолчанию < 500
+	BodyContains string         // Подстрока, которая должна быть в теле ответа
+	BodyRegex    *regexp.Regexp // Регулярное выражение для тела ответа
+}
+
+// Probe отправляет запрос и проверяет код и тело ответа
+func (p *HTTPProbe) Probe(ctx context.Context, b loadbalancer.Backend) error {
+	method := p.Method
+	if method == "" {
+		method = http.MethodHead
+	}
+	u := *b.URL()
+	if p.Path != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(p.Path, "/")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	for k, vs := range p.Headers {
+		if http.CanonicalHeaderKey(k) == "Host" && len(vs) != 0 {
+			req.Host = vs[0]
+			continue
+		}
+		req.Header[k] = vs
+	}
+
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !p.statusOK(resp.StatusCode) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if p.BodyContains == "" && p.BodyRegex == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyCheck))
+	if err != nil {
+		return err
+	}
+	if p.BodyContains != "" && !strings.Contains(string(body), p.BodyContains) {
+		return fmt.Errorf("body doesn't contain %q", p.BodyContains)
+	}
+	if p.BodyRegex != nil && !p.BodyRegex.Match(body) {
+		return fmt.Errorf("body doesn't match %q", p.BodyRegex)
+	}
+	return nil
+}
+
+// Проверяет код ответа по допустимым диапазонам
+func (p *HTTPProbe) statusOK(code int) bool {
+	if len(p.Statuses) == 0 {
+		return code < 500
+	}
+	for _, r := range p.Statuses {
+		if code >= r.From && code <= r.To {
+			return true
+		}
+	}
+	return false
+}
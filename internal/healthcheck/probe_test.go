@@ -0,0 +1,129 @@
+package healthcheck
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+func TestTCPProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := loadbalancer.NewBackend("http://" + ln.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := (TCPProbe{}).Probe(ctx, b); err != nil {
+		t.Errorf("expected tcp probe success, got %v", err)
+	}
+
+	ln.Close()
+	if err := (TCPProbe{}).Probe(ctx, b); err == nil {
+		t.Error("expected tcp probe failure on closed port")
+	}
+}
+
+func TestGRPCProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Stop()
+
+	b, _ := loadbalancer.NewBackend("http://" + ln.Addr().String())
+	c := New([]loadbalancer.Backend{b}, time.Second,
+		WithProbe(&HTTPProbe{}),
+		WithBackendProbes(map[string]Prober{b.Name(): GRPCProbe{Service: "users"}}))
+
+	hs.SetServingStatus("users", healthpb.HealthCheckResponse_SERVING)
+	b.SetAlive(false)
+	c.check(b)
+	if !b.Alive() {
+		t.Error("expected serving backend alive")
+	}
+
+	hs.SetServingStatus("users", healthpb.HealthCheckResponse_NOT_SERVING)
+	c.check(b)
+	if b.Alive() {
+		t.Error("expected not serving backend down")
+	}
+}
+
+// Считает принятые и открытые соединения
+type countListener struct {
+	net.Listener
+	accepted atomic.Int32
+	open     atomic.Int32
+}
+
+func (l *countListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.accepted.Add(1)
+	l.open.Add(1)
+	return &countConn{Conn: conn, l: l}, nil
+}
+
+type countConn struct {
+	net.Conn
+	l    *countListener
+	once sync.Once
+}
+
+func (c *countConn) Close() error {
+	c.once.Do(func() { c.l.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+func TestGRPCProbe_ReusesConn(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &countListener{Listener: inner}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Stop()
+
+	b, _ := loadbalancer.NewBackend("http://" + inner.Addr().String())
+	c := New([]loadbalancer.Backend{b}, time.Second, WithProbe(GRPCProbe{}))
+	for i := 0; i < 5; i++ {
+		c.due(b, time.Now())
+		c.check(b)
+	}
+	if !b.Alive() {
+		t.Fatal("expected serving backend alive")
+	}
+	if n := ln.accepted.Load(); n != 1 {
+		t.Errorf("expected one connection for all probes, got %d", n)
+	}
+
+	// Сервер ушел из пула - соединение закрывается
+	c.SetBackends(nil)
+	deadline := time.Now().Add(2 * time.Second)
+	for ln.open.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := ln.open.Load(); n != 0 {
+		t.Errorf("expected connection closed after removal, %d open", n)
+	}
+}
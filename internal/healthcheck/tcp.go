@@ -0,0 +1,34 @@
+package healthcheck
+
+import (
+	"context"
+	"net"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// TCPProbe считает сервер живым, если к нему удается установить TCP-соединение
+type TCPProbe struct{}
+
+// Probe устанавливает соединение с адресом сервера и сразу закрывает его
+func (TCPProbe) Probe(ctx context.Context, b loadbalancer.Backend) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hostPort(b))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Возвращает адрес сервера с портом, по умолчанию порт определяется схемой URL
+func hostPort(b loadbalancer.Backend) string {
+	u := b.URL()
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" || u.Scheme == "grpcs" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
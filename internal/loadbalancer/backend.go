@@ -1,15 +1,31 @@
 package loadbalancer
 
 import (
+	"fmt"
 	"net/url"
 	"sync/atomic"
+	"time"
 )
 
+// Версия состояния пула, увеличивается при каждом изменении живости серверов.
+// Селекторы с предрасчитанными таблицами сверяют ее, чтобы понять, когда перестраиваться
+var stateVersion atomic.Uint64
+
 // backend реализует интерфейс Backend, представляя один реальный сервер
 type backend struct {
+	name        string       // Имя сервера, уникальное в пуле
 	url         *url.URL     // Адрес
 	alive       atomic.Bool  // Состояние: жив/мертв
+	draining    atomic.Bool  // Выводится из работы
+	tripped     atomic.Bool  // Исключен автоматом защиты
 	activeConns atomic.Int64 // Количество активных соединений
+	weight      atomic.Int64 // Вес сервера
+	priority    atomic.Int64 // Приоритет группы
+	slowStart   atomic.Int64 // Длительность прогрева в наносекундах
+	rampStart   atomic.Int64 // Начало прогрева (unix nano), 0 - прогрев не идет
+	maxConns    atomic.Int64 // Лимит активных соединений, 0 - без лимита
+	zone        atomic.Value // Зона доступности (string)
+	latency     peakEWMA     // Задержка ответов
 }
 
 // Создание нового бэкенда
@@ -18,22 +34,105 @@ func NewBackend(raw string) (*backend, error) {
 	if err != nil {
 		return nil, err
 	}
-	b := &backend{url: u}
+	b := &backend{name: raw, url: u}
 	b.alive.Store(true) // По умолчанию считаем живым
+	b.weight.Store(1)
+	return b, nil
+}
+
+// Параметры сервера из конфига или admin API
+type Spec struct {
+	Name      string        `json:"name"`
+	URL       string        `json:"url"`
+	Weight    int           `json:"weight"`
+	Priority  int           `json:"priority"`
+	MaxConns  int64         `json:"max_conns"`
+	Zone      string        `json:"zone"`
+	Draining  bool          `json:"draining"`
+	SlowStart time.Duration `json:"-"`
+}
+
+// Создает бэкенд по параметрам, без имени используется URL
+func NewFromSpec(s Spec) (*backend, error) {
+	if s.URL == "" {
+		return nil, fmt.Errorf("backend %q: empty url", s.Name)
+	}
+	b, err := NewBackend(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	// Запросы проксируются по HTTP, без схемы и хоста сервер недоступен
+	if (b.url.Scheme != "http" && b.url.Scheme != "https") || b.url.Host == "" {
+		return nil, fmt.Errorf("backend %q: url %q must be http(s)://host", s.Name, s.URL)
+	}
+	if s.Name != "" {
+		b.name = s.Name
+	}
+	b.SetWeight(s.Weight)
+	b.SetPriority(s.Priority)
+	b.SetSlowStart(s.SlowStart)
+	b.SetMaxConns(s.MaxConns)
+	b.SetZone(s.Zone)
+	b.SetDraining(s.Draining)
 	return b, nil
 }
 
+// Возвращает имя бэкенда
+func (b *backend) Name() string { return b.name }
+
 // Возвращает URL бэкенда
 func (b *backend) URL() *url.URL { return b.url }
 
 // Возвращает текущее состояние сервера
 func (b *backend) Alive() bool { return b.alive.Load() }
 
-// Обновляет состояние сервера
-func (b *backend) SetAlive(v bool) { b.alive.Store(v) }
+// Обновляет состояние сервера. Восстановившийся сервер начинает прогрев
+func (b *backend) SetAlive(v bool) {
+	if b.alive.Swap(v) != v {
+		if v {
+			b.StartRamp()
+		}
+		stateVersion.Add(1)
+	}
+}
+
+// Возвращает true, если сервер выводится из работы
+func (b *backend) Draining() bool { return b.draining.Load() }
+
+// Включает или выключает вывод из работы: новые запросы на сервер не идут,
+// а начатые завершаются
+func (b *backend) SetDraining(v bool) {
+	if b.draining.Swap(v) != v {
+		stateVersion.Add(1)
+	}
+}
+
+// Возвращает true, если сервер исключен разомкнутым автоматом защиты
+func (b *backend) Tripped() bool { return b.tripped.Load() }
+
+// Исключает сервер по автомату защиты или возвращает его. Состояние
+// независимо от Alive, которым управляет health check
+func (b *backend) SetTripped(v bool) {
+	if b.tripped.Swap(v) != v {
+		if !v {
+			b.StartRamp()
+		}
+		stateVersion.Add(1)
+	}
+}
 
-// Увеличивает счётчик активных соединений
-func (b *backend) Inc() { b.activeConns.Add(1) }
+// Увеличивает счётчик активных соединений, если не достигнут лимит
+func (b *backend) Acquire() bool {
+	for {
+		n := b.activeConns.Load()
+		if limit := b.maxConns.Load(); limit > 0 && n >= limit {
+			return false
+		}
+		if b.activeConns.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
 
 // Уменьшает счётчик активных соединений
 func (b *backend) Done() {
@@ -44,3 +143,66 @@ func (b *backend) Done() {
 
 // Возвращает текущее число активных соединений
 func (b *backend) Conns() int64 { return b.activeConns.Load() }
+
+// Возвращает зону доступности сервера
+func (b *backend) Zone() string {
+	z, _ := b.zone.Load().(string)
+	return z
+}
+
+// Устанавливает зону доступности сервера
+func (b *backend) SetZone(z string) {
+	b.zone.Store(z)
+	stateVersion.Add(1)
+}
+
+// Возвращает лимит активных соединений
+func (b *backend) MaxConns() int64 { return b.maxConns.Load() }
+
+// Устанавливает лимит активных соединений, 0 - без лимита
+func (b *backend) SetMaxConns(n int64) { b.maxConns.Store(max(n, 0)) }
+
+// Возвращает вес сервера
+func (b *backend) Weight() int { return int(b.weight.Load()) }
+
+// Устанавливает вес сервера, значения меньше 1 приводятся к 1
+func (b *backend) SetWeight(w int) {
+	if w < 1 {
+		w = 1
+	}
+	b.weight.Store(int64(w))
+}
+
+// Возвращает приоритет группы сервера
+func (b *backend) Priority() int { return int(b.priority.Load()) }
+
+// Устанавливает приоритет группы сервера
+func (b *backend) SetPriority(p int) {
+	b.priority.Store(int64(p))
+	stateVersion.Add(1)
+}
+
+// Устанавливает длительность прогрева, 0 - без прогрева
+func (b *backend) SetSlowStart(d time.Duration) { b.slowStart.Store(int64(d)) }
+
+// Начинает прогрев: доля трафика сервера растет линейно в течение окна slow start
+func (b *backend) StartRamp() { b.rampStart.Store(time.Now().UnixNano()) }
+
+// Возвращает долю веса сервера с учетом прогрева
+func (b *backend) Ramp() float64 {
+	start, window := b.rampStart.Load(), b.slowStart.Load()
+	if start == 0 || window <= 0 {
+		return 1
+	}
+	elapsed := time.Now().UnixNano() - start
+	if elapsed >= window {
+		return 1
+	}
+	return minRamp + (1-minRamp)*float64(elapsed)/float64(window)
+}
+
+// Учитывает время ответа сервера
+func (b *backend) ObserveLatency(d time.Duration) { b.latency.observe(d) }
+
+// Возвращает сглаженную задержку ответа
+func (b *backend) Latency() time.Duration { return b.latency.load() }
@@ -0,0 +1,67 @@
+package loadbalancer
+
+import (
+	"math"
+	"net/http"
+)
+
+// Коэффициент допустимой нагрузки по умолчанию
+const defaultLoadFactor = 1.25
+
+// boundedHash реализует консистентное хеширование с ограниченной нагрузкой:
+// ни один сервер не получает больше factor * средней нагрузки
+type boundedHash struct {
+	*consistentHash
+	factor float64 // Во сколько раз нагрузка сервера может превышать среднюю
+}
+
+// Возвращает селектор консистентного хеширования с ограничением нагрузки
+func NewBoundedHash(bs []Backend, key KeyFunc, replicas int, factor float64) Selector {
+	if factor < 1 {
+		factor = defaultLoadFactor
+	}
+	ch := NewConsistentHash(bs, key, replicas).(*consistentHash)
+	return &boundedHash{consistentHash: ch, factor: factor}
+}
+
+// Next идет по кольцу от хеша ключа и выбирает первый живой сервер,
+// у которого после добавления запроса нагрузка не превысит лимит
+func (bh *boundedHash) Next(r *http.Request) Backend {
+	if len(bh.ring) == 0 {
+		return nil
+	}
+
+	// Считаем суммарную нагрузку по живым серверам
+	var total int64
+	alive := 0
+	for _, b := range bh.backends {
+		if b.Alive() {
+			total += b.Conns()
+			alive++
+		}
+	}
+	if alive == 0 {
+		return nil
+	}
+	limit := int64(math.Ceil(bh.factor * float64(total+1) / float64(alive)))
+
+	var key string
+	if r != nil {
+		key = bh.key(r)
+	}
+	start := bh.search(hashKey(key))
+	var first Backend
+	for i := 0; i != len(bh.ring); i++ {
+		b := bh.ring[(start+i)%len(bh.ring)].backend
+		if !Available(b) {
+			continue
+		}
+		if first == nil {
+			first = b
+		}
+		if b.Conns()+1 <= limit && admit(b) {
+			return b
+		}
+	}
+	return first
+}
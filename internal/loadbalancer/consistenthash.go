@@ -0,0 +1,114 @@
+package loadbalancer
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Количество виртуальных узлов на сервер по умолчанию
+const defaultReplicas = 160
+
+// Точка на кольце
+type ringPoint struct {
+	hash    uint32
+	backend Backend
+}
+
+// consistentHash реализует консистентное хеширование (кольцо ketama с виртуальными узлами)
+type consistentHash struct {
+	backends []Backend
+	ring     []ringPoint // Точки, отсортированные по hash
+	key      KeyFunc     // Источник ключа запроса
+}
+
+// Возвращает селектор, отправляющий запросы с одинаковым ключом на один и тот же сервер.
+// При добавлении или удалении сервера меняется привязка только ~1/N ключей
+func NewConsistentHash(bs []Backend, key KeyFunc, replicas int) Selector {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	if key == nil {
+		key = ClientIP
+	}
+	return &consistentHash{backends: bs, ring: buildRing(bs, replicas), key: key}
+}
+
+// Строит кольцо: каждый md5 дает 4 точки, как в ketama
+func buildRing(bs []Backend, replicas int) []ringPoint {
+	ring := make([]ringPoint, 0, len(bs)*replicas)
+	for _, b := range bs {
+		id := b.URL().String()
+		for i := 0; i < (replicas+3)/4; i++ {
+			sum := md5.Sum([]byte(id + "-" + strconv.Itoa(i)))
+			for j := 0; j != 4; j++ {
+				ring = append(ring, ringPoint{
+					hash:    binary.LittleEndian.Uint32(sum[j*4:]),
+					backend: b,
+				})
+			}
+		}
+	}
+	slices.SortFunc(ring, func(a, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return 0
+	})
+	return ring
+}
+
+// Хеширует ключ запроса в точку на кольце
+func hashKey(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(sum[:4])
+}
+
+// Возвращает индекс первой точки кольца, не меньшей h
+func (ch *consistentHash) search(h uint32) int {
+	i, _ := slices.BinarySearchFunc(ch.ring, h, func(p ringPoint, h uint32) int {
+		switch {
+		case p.hash < h:
+			return -1
+		case p.hash > h:
+			return 1
+		}
+		return 0
+	})
+	if i == len(ch.ring) {
+		i = 0
+	}
+	return i
+}
+
+// Next выбирает первый живой сервер по часовой стрелке от хеша ключа.
+// Сервер на прогреве может уступить часть ключей следующему по кольцу
+func (ch *consistentHash) Next(r *http.Request) Backend {
+	if len(ch.ring) == 0 {
+		return nil
+	}
+	var key string
+	if r != nil {
+		key = ch.key(r)
+	}
+	start := ch.search(hashKey(key))
+	var first Backend
+	for i := 0; i != len(ch.ring); i++ {
+		b := ch.ring[(start+i)%len(ch.ring)].backend
+		if !Available(b) {
+			continue
+		}
+		if first == nil {
+			first = b
+		}
+		if admit(b) {
+			return b
+		}
+	}
+	return first
+}
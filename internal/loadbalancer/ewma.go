@@ -0,0 +1,49 @@
+package loadbalancer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Время затухания peak-EWMA: старые замеры теряют вес за это время
+const ewmaDecay = 10 * time.Second
+
+// peakEWMA хранит экспоненциально сглаженную задержку ответа.
+// Пиковые значения принимаются сразу, а снижение происходит плавно,
+// поэтому деградировавший сервер быстро теряет трафик
+type peakEWMA struct {
+	mu    sync.Mutex
+	value float64   // Текущая оценка задержки в наносекундах
+	stamp time.Time // Время последнего замера
+}
+
+// Учитывает новый замер задержки
+func (e *peakEWMA) observe(rtt time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	v := float64(rtt)
+	switch {
+	case e.stamp.IsZero() || v > e.value:
+		e.value = v // Пик запоминаем без сглаживания
+	default:
+		w := math.Exp(-float64(now.Sub(e.stamp)) / float64(ewmaDecay))
+		e.value = e.value*w + v*(1-w)
+	}
+	e.stamp = now
+}
+
+// Возвращает текущую оценку задержки. Оценка затухает со временем без
+// замеров, иначе сервер после одного пика не получал бы трафика и новых
+// замеров и оставался бы исключенным навсегда
+func (e *peakEWMA) load() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stamp.IsZero() {
+		return 0
+	}
+	w := math.Exp(-float64(time.Since(e.stamp)) / float64(ewmaDecay))
+	return time.Duration(e.value * w)
+}
@@ -0,0 +1,56 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// KeyFunc извлекает из запроса ключ, по которому hash-селекторы выбирают сервер
+type KeyFunc func(r *http.Request) string
+
+// Возвращает KeyFunc для указанного источника ключа:
+// header, cookie, query - значение с именем name, path - путь запроса, ip - адрес клиента
+func NewKeyFunc(source, name string) (KeyFunc, error) {
+	var key KeyFunc
+	switch source {
+	case "header":
+		key = func(r *http.Request) string { return r.Header.Get(name) }
+	case "cookie":
+		key = func(r *http.Request) string {
+			c, err := r.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}
+	case "query":
+		key = func(r *http.Request) string { return r.URL.Query().Get(name) }
+	case "path":
+		key = func(r *http.Request) string { return r.URL.Path }
+	case "", "ip":
+		return ClientIP, nil
+	default:
+		return nil, fmt.Errorf("unknown hash key source %q", source)
+	}
+	if name == "" && source != "path" {
+		return nil, fmt.Errorf("hash key source %q requires name", source)
+	}
+
+	// Если ключ в запросе отсутствует, то используем адрес клиента
+	return func(r *http.Request) string {
+		if k := key(r); k != "" {
+			return k
+		}
+		return ClientIP(r)
+	}, nil
+}
+
+// Возвращает IP клиента без порта
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,5 +1,7 @@
 package loadbalancer
 
+import "net/http"
+
 // leastConn реализует "наименьшее количество соединений"
 type leastConn struct {
 	backends []Backend
@@ -10,15 +12,18 @@ func NewLeastConnections(bs []Backend) Selector {
 	return &leastConn{backends: bs}
 }
 
-// Next выбирает backend с минимальным количеством активных соединений
-func (lc *leastConn) Next() Backend {
+// Next выбирает backend с минимальным количеством активных соединений.
+// У сервера на прогреве нагрузка считается пропорционально больше
+func (lc *leastConn) Next(*http.Request) Backend {
 	var best Backend
+	var bestLoad float64
 	for _, b := range lc.backends {
-		if !b.Alive() {
+		if !Available(b) {
 			continue // Игнорирует мертвые сервера
 		}
-		if best == nil || b.Conns() < best.Conns() {
-			best = b
+		load := float64(b.Conns()+1) / b.Ramp()
+		if best == nil || load < bestLoad {
+			best, bestLoad = b, load
 		}
 	}
 	return best
@@ -0,0 +1,168 @@
+package loadbalancer
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Размер таблицы поиска по умолчанию, должен быть простым числом
+const defaultMaglevSize = 65537
+
+// Таблица поиска, построенная для определенной версии состояния пула
+type maglevTable struct {
+	version uint64
+	entries []Backend
+}
+
+// maglev реализует хеширование Maglev: O(1) поиск по таблице и почти равномерное распределение
+type maglev struct {
+	backends []Backend
+	key      KeyFunc
+	size     int
+	mu       sync.Mutex // Защищает перестроение таблицы
+	table    atomic.Pointer[maglevTable]
+}
+
+// Возвращает селектор Maglev. Таблица перестраивается при изменении живости серверов
+// или режима вывода из работы
+func NewMaglev(bs []Backend, key KeyFunc, size int) Selector {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	if key == nil {
+		key = ClientIP
+	}
+	m := &maglev{backends: bs, key: key, size: nextPrime(size)}
+	m.rebuild()
+	return m
+}
+
+// Next выбирает сервер по хешу ключа из таблицы
+func (m *maglev) Next(r *http.Request) Backend {
+	t := m.table.Load()
+	if t.version != stateVersion.Load() {
+		t = m.rebuild()
+	}
+	if len(t.entries) == 0 {
+		return nil
+	}
+	var key string
+	if r != nil {
+		key = m.key(r)
+	}
+	h := hashKey(key)
+	n := uint32(len(t.entries))
+	b := t.entries[h%n]
+	if !Available(b) {
+		if b = m.nextAvailable(t, h%n); b == nil {
+			return nil
+		}
+	}
+	if !admit(b) {
+		// Сервер на прогреве уступает часть ключей серверу из другой ячейки
+		if alt := t.entries[(h>>16|h<<16)%n]; Available(alt) && admit(alt) {
+			return alt
+		}
+	}
+	return b
+}
+
+// Возвращает первый доступный сервер в таблице после позиции idx
+func (m *maglev) nextAvailable(t *maglevTable, idx uint32) Backend {
+	// Сначала убеждаемся, что такой есть, чтобы не обходить всю таблицу
+	found := false
+	for _, b := range m.backends {
+		if Available(b) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	n := uint32(len(t.entries))
+	for i := uint32(1); i != n; i++ {
+		if b := t.entries[(idx+i)%n]; Available(b) {
+			return b
+		}
+	}
+	return nil
+}
+
+// Перестраивает таблицу по живым серверам, если она устарела
+func (m *maglev) rebuild() *maglevTable {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	version := stateVersion.Load()
+	if t := m.table.Load(); t != nil && t.version == version {
+		return t
+	}
+
+	var alive []Backend
+	for _, b := range m.backends {
+		if Serving(b) {
+			alive = append(alive, b)
+		}
+	}
+	t := &maglevTable{version: version}
+	if len(alive) != 0 {
+		t.entries = populate(alive, m.size)
+	}
+	m.table.Store(t)
+	return t
+}
+
+// Заполняет таблицу: серверы по очереди занимают первую свободную позицию
+// из своей перестановки, пока таблица не заполнится
+func populate(bs []Backend, size int) []Backend {
+	m := uint64(size)
+	offset := make([]uint64, len(bs))
+	skip := make([]uint64, len(bs))
+	for i, b := range bs {
+		sum := md5.Sum([]byte(b.URL().String()))
+		offset[i] = binary.LittleEndian.Uint64(sum[:8]) % m
+		skip[i] = binary.LittleEndian.Uint64(sum[8:])%(m-1) + 1
+	}
+
+	entries := make([]Backend, size)
+	next := make([]uint64, len(bs))
+	filled := 0
+	for {
+		for i, b := range bs {
+			c := (offset[i] + next[i]*skip[i]) % m
+			for entries[c] != nil {
+				next[i]++
+				c = (offset[i] + next[i]*skip[i]) % m
+			}
+			entries[c] = b
+			next[i]++
+			filled++
+			if filled == size {
+				return entries
+			}
+		}
+	}
+}
+
+// Возвращает наименьшее простое число, не меньшее n
+func nextPrime(n int) int {
+	if n < 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for d := 2; d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package loadbalancer
+
+import (
+	"math/rand"
+	"net/http"
+)
+
+// Сколько раз пробуем выбрать пару живых серверов, прежде чем перебрать всех
+const p2cTries = 3
+
+// p2c реализует "power of two choices": из двух случайных живых серверов
+// выбирается тот, у которого меньше произведение задержки на число запросов
+type p2c struct {
+	backends []Backend
+}
+
+// Возвращает селектор P2C с оценкой по peak-EWMA задержке
+func NewP2C(bs []Backend) Selector {
+	return &p2c{backends: bs}
+}
+
+// Next сравнивает двух случайных живых серверов и возвращает менее загруженный
+func (p *p2c) Next(*http.Request) Backend {
+	n := len(p.backends)
+	switch n {
+	case 0:
+		return nil
+	case 1:
+		if Available(p.backends[0]) {
+			return p.backends[0]
+		}
+		return nil
+	}
+
+	for i := 0; i != p2cTries; i++ {
+		a, b := pickTwo(n)
+		if Available(p.backends[a]) && Available(p.backends[b]) {
+			return cheaper(p.backends[a], p.backends[b])
+		}
+	}
+
+	// Живых мало - выбираем пару среди них
+	alive := make([]Backend, 0, n)
+	for _, b := range p.backends {
+		if Available(b) {
+			alive = append(alive, b)
+		}
+	}
+	switch len(alive) {
+	case 0:
+		return nil
+	case 1:
+		return alive[0]
+	}
+	a, b := pickTwo(len(alive))
+	return cheaper(alive[a], alive[b])
+}
+
+// Возвращает два разных случайных индекса из [0, n), n >= 2
+func pickTwo(n int) (int, int) {
+	a := rand.Intn(n)
+	b := rand.Intn(n - 1)
+	if b >= a {
+		b++
+	}
+	return a, b
+}
+
+// Возвращает сервер с меньшей оценкой стоимости
+func cheaper(a, b Backend) Backend {
+	if score(b) < score(a) {
+		return b
+	}
+	return a
+}
+
+// Оценка стоимости сервера: задержка * (запросы в обработке + 1) / доля веса на прогреве.
+// Сервер без замеров получает нулевую задержку, чтобы на него пошел трафик
+func score(b Backend) float64 {
+	return float64(b.Latency()) * float64(b.Conns()+1) / b.Ramp()
+}
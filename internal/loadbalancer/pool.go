@@ -0,0 +1,172 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Pool хранит изменяемый во время работы список серверов.
+// При каждом изменении селектор пересоздается и атомарно подменяется,
+// поэтому запросы в обработке продолжают работать со своими серверами
+type Pool struct {
+	mu        sync.RWMutex
+	backends  []Backend
+	byName    map[string]Backend
+	factory   Factory
+	sel       atomic.Pointer[Selector]
+	listeners []func([]Backend) // Вызываются со списком серверов после каждого изменения
+}
+
+// Создает пул из начального списка серверов
+func NewPool(bs []Backend, factory Factory) (*Pool, error) {
+	p := &Pool{byName: make(map[string]Backend, len(bs)), factory: factory}
+	for _, b := range bs {
+		if _, ok := p.byName[b.Name()]; ok {
+			return nil, fmt.Errorf("duplicate backend %q", b.Name())
+		}
+		p.byName[b.Name()] = b
+		p.backends = append(p.backends, b)
+	}
+	p.rebuild()
+	return p, nil
+}
+
+// Next выбирает сервер текущим селектором
+func (p *Pool) Next(r *http.Request) Backend {
+	return (*p.sel.Load()).Next(r)
+}
+
+// Выбор сужает текущий селектор
+func (p *Pool) narrow() (Selector, []Backend) {
+	sel := *p.sel.Load()
+	if n, ok := sel.(narrower); ok {
+		return n.narrow()
+	}
+	return nil, nil
+}
+
+// Подписывает fn на изменения пула и сразу вызывает ее с текущим списком
+func (p *Pool) OnChange(fn func([]Backend)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listeners = append(p.listeners, fn)
+	fn(p.list())
+}
+
+// Добавляет сервер, имя должно быть уникальным
+func (p *Pool) Add(b Backend) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.byName[b.Name()]; ok {
+		return fmt.Errorf("backend %q already exists", b.Name())
+	}
+	p.byName[b.Name()] = b
+	p.backends = append(p.backends, b)
+	p.changed()
+	return nil
+}
+
+// Удаляет сервер по имени и возвращает его
+func (p *Pool) Remove(name string) (Backend, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("backend %q not found", name)
+	}
+	delete(p.byName, name)
+	for i, cur := range p.backends {
+		if cur == b {
+			p.backends = append(p.backends[:i:i], p.backends[i+1:]...)
+			break
+		}
+	}
+	p.changed()
+	return b, nil
+}
+
+// Применяет изменения одной операцией: удаляет серверы по именам и добавляет новые.
+// Если изменение некорректно, пул не меняется
+func (p *Pool) Update(add []Backend, remove []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	gone := make(map[string]bool, len(remove))
+	for _, name := range remove {
+		if _, ok := p.byName[name]; !ok {
+			return fmt.Errorf("backend %q not found", name)
+		}
+		gone[name] = true
+	}
+	added := make(map[string]bool, len(add))
+	for _, b := range add {
+		if _, ok := p.byName[b.Name()]; (ok && !gone[b.Name()]) || added[b.Name()] {
+			return fmt.Errorf("backend %q already exists", b.Name())
+		}
+		added[b.Name()] = true
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+
+	kept := p.backends[:0:0]
+	for _, b := range p.backends {
+		if gone[b.Name()] {
+			delete(p.byName, b.Name())
+			continue
+		}
+		kept = append(kept, b)
+	}
+	for _, b := range add {
+		p.byName[b.Name()] = b
+		kept = append(kept, b)
+	}
+	p.backends = kept
+	p.changed()
+	return nil
+}
+
+// Пересоздает селектор, например после изменения приоритета или зоны сервера
+func (p *Pool) Rebuild() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.changed()
+}
+
+// Возвращает сервер по имени или nil
+func (p *Pool) Get(name string) Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.byName[name]
+}
+
+// Возвращает копию списка серверов
+func (p *Pool) List() []Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.list()
+}
+
+func (p *Pool) list() []Backend {
+	out := make([]Backend, len(p.backends))
+	copy(out, p.backends)
+	return out
+}
+
+// Пересоздает селектор и оповещает подписчиков, вызывается под p.mu
+func (p *Pool) changed() {
+	p.rebuild()
+	stateVersion.Add(1)
+	bs := p.list()
+	for _, fn := range p.listeners {
+		fn(bs)
+	}
+}
+
+// Создает селектор для текущего списка серверов
+func (p *Pool) rebuild() {
+	sel := p.factory(p.list())
+	p.sel.Store(&sel)
+}
@@ -1,6 +1,9 @@
 package loadbalancer
 
-import "math/rand"
+import (
+	"math/rand"
+	"net/http"
+)
 
 // random реализует случайный выбор сервера
 type random struct {
@@ -11,16 +14,24 @@ func NewRandom(bs []Backend) Selector {
 	return &random{backends: bs}
 }
 
-// Возвращает случайный живой backend
-func (r *random) Next() Backend {
+// Возвращает случайный живой backend, вероятность пропорциональна доле веса на прогреве
+func (r *random) Next(*http.Request) Backend {
 	alive := make([]Backend, 0, len(r.backends))
+	var total float64
 	for _, b := range r.backends {
-		if b.Alive() {
+		if Available(b) {
 			alive = append(alive, b)
+			total += b.Ramp()
 		}
 	}
 	if len(alive) == 0 {
 		return nil
 	}
-	return alive[rand.Intn(len(alive))]
+	x := rand.Float64() * total
+	for _, b := range alive {
+		if x -= b.Ramp(); x < 0 {
+			return b
+		}
+	}
+	return alive[len(alive)-1]
 }
@@ -1,6 +1,9 @@
 package loadbalancer
 
-import "sync"
+import (
+	"net/http"
+	"sync"
+)
 
 // roundRobin реализует алгоритм по кругу
 type roundRobin struct {
@@ -13,18 +16,31 @@ func NewRoundRobin(bs []Backend) Selector {
 	return &roundRobin{backends: bs}
 }
 
-// Выбирает следующий живой backend по очереди
-func (rr *roundRobin) Next() Backend {
+// Выбирает следующий живой backend по очереди.
+// Сервер на прогреве может быть пропущен, если подходящих нет - берется первый живой
+func (rr *roundRobin) Next(*http.Request) Backend {
 	rr.mu.Lock()
 	defer rr.mu.Unlock()
 
 	n := len(rr.backends)
+	first := -1
 	for i := 0; i != n; i++ {
-		b := rr.backends[(rr.idx+i)%n]
-		if b.Alive() {
-			rr.idx = (rr.idx + i + 1) % n
+		j := (rr.idx + i) % n
+		b := rr.backends[j]
+		if !Available(b) {
+			continue
+		}
+		if first == -1 {
+			first = j
+		}
+		if admit(b) {
+			rr.idx = (j + 1) % n
 			return b
 		}
 	}
-	return nil
+	if first == -1 {
+		return nil
+	}
+	rr.idx = (first + 1) % n
+	return rr.backends[first]
 }
@@ -1,18 +1,101 @@
 package loadbalancer
 
-import "net/url"
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
 
 // Интерфейс сервера
 type Backend interface {
-	URL() *url.URL // Адрес
-	Alive() bool   // Состояние жив/мертв
-	SetAlive(bool) // Установка состояния
-	Inc()          // +1 к количеству активных соединений
-	Done()         // -1 при завершении запроса
-	Conns() int64  // Получить текущее количество соединений
+	Name() string     // Имя
+	URL() *url.URL    // Адрес
+	Alive() bool      // Состояние жив/мертв
+	SetAlive(bool)    // Установка состояния
+	Draining() bool   // Выводится из работы: новые запросы не принимает
+	SetDraining(bool) // Установка режима вывода из работы
+	Tripped() bool    // Исключен разомкнутым автоматом защиты
+	SetTripped(bool)  // Установка исключения автоматом защиты
+	Done()            // -1 при завершении запроса
+	Conns() int64     // Получить текущее количество соединений
+	Weight() int      // Вес сервера
+	SetWeight(int)    // Установка веса
+	Priority() int    // Приоритет группы, 0 - самая приоритетная
+	SetPriority(int)  // Установка приоритета, требует Pool.Rebuild
+	Zone() string     // Зона доступности
+	SetZone(string)   // Установка зоны, требует Pool.Rebuild
+	Ramp() float64    // Доля веса на прогреве после восстановления, от 0 до 1
+
+	MaxConns() int64   // Лимит активных соединений, 0 - без лимита
+	SetMaxConns(int64) // Установка лимита соединений
+	Acquire() bool     // +1 к количеству соединений, если лимит не достигнут
+
+	ObserveLatency(time.Duration) // Учесть время ответа сервера
+	Latency() time.Duration       // Сглаженная (peak-EWMA) задержка ответа
+}
+
+// Available сообщает, может ли сервер принять новый запрос:
+// жив, не выводится из работы, не исключен автоматом защиты
+// и не упирается в лимит соединений
+func Available(b Backend) bool {
+	if !Serving(b) {
+		return false
+	}
+	limit := b.MaxConns()
+	return limit == 0 || b.Conns() < limit
+}
+
+// Serving сообщает, что сервер жив, не выводится из работы и не исключен
+// автоматом защиты. В отличие от Available лимит соединений не учитывается
+func Serving(b Backend) bool {
+	return b.Alive() && !b.Draining() && !b.Tripped()
+}
+
+// Возвращает долю живых серверов в списке, выводимые из работы
+// и исключенные автоматом защиты не учитываются
+func aliveShare(bs []Backend) float64 {
+	if len(bs) == 0 {
+		return 0
+	}
+	alive := 0
+	for _, b := range bs {
+		if Serving(b) {
+			alive++
+		}
+	}
+	return float64(alive) / float64(len(bs))
 }
 
 // Интерфейс выбора сервера
 type Selector interface {
-	Next() Backend // возвращает выбранный сервер
+	Next(r *http.Request) Backend // возвращает выбранный сервер для запроса
+}
+
+// Селектор, который сейчас выбирает только из части пула, например из
+// активной группы приоритета или своей зоны. Возвращает вложенный селектор
+// этой части и ее серверы, nil - выбор идет по всему пулу
+type narrower interface {
+	narrow() (Selector, []Backend)
+}
+
+// Preferred возвращает части пула, из которых сейчас выбирает селектор,
+// от самой узкой к широкой: например, своя зона в активной группе
+// приоритета, затем вся группа. Весь пул не включается
+func Preferred(sel Selector) [][]Backend {
+	var out [][]Backend
+	for {
+		n, ok := sel.(narrower)
+		if !ok {
+			break
+		}
+		inner, bs := n.narrow()
+		if bs == nil {
+			break
+		}
+		out = append(out, bs)
+		sel = inner
+	}
+	slices.Reverse(out)
+	return out
 }
@@ -1,36 +1,80 @@
 package loadbalancer
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 )
 
 type mockBackend struct {
-	u     string
-	alive bool
-	conns int64
+	u        string
+	alive    bool
+	conns    int64
+	weight   int
+	priority int
+	ramp     float64
+	maxConns int64
+	zone     string
+	draining bool
+	tripped  bool
+	latency  time.Duration
 }
 
+func (m *mockBackend) Name() string { return m.u }
 func (f *mockBackend) URL() *url.URL {
 	u, _ := url.Parse(f.u)
 	return u
 }
-func (m *mockBackend) Alive() bool     { return m.alive }
-func (m *mockBackend) SetAlive(v bool) { m.alive = v }
-func (m *mockBackend) Inc()            { m.conns++ }
-func (m *mockBackend) Done()           { m.conns-- }
-func (m *mockBackend) Conns() int64    { return m.conns }
+func (m *mockBackend) Alive() bool        { return m.alive }
+func (m *mockBackend) SetAlive(v bool)    { m.alive = v; stateVersion.Add(1) }
+func (m *mockBackend) Draining() bool     { return m.draining }
+func (m *mockBackend) SetDraining(v bool) { m.draining = v; stateVersion.Add(1) }
+func (m *mockBackend) Tripped() bool      { return m.tripped }
+func (m *mockBackend) SetTripped(v bool)  { m.tripped = v; stateVersion.Add(1) }
+func (m *mockBackend) Done()              { m.conns-- }
+func (m *mockBackend) Conns() int64       { return m.conns }
+func (m *mockBackend) Weight() int {
+	if m.weight == 0 {
+		return 1
+	}
+	return m.weight
+}
+func (m *mockBackend) SetWeight(w int)   { m.weight = w }
+func (m *mockBackend) SetPriority(p int) { m.priority = p }
+func (m *mockBackend) SetZone(z string)  { m.zone = z }
+func (m *mockBackend) Priority() int     { return m.priority }
+func (m *mockBackend) Ramp() float64 {
+	if m.ramp == 0 {
+		return 1
+	}
+	return m.ramp
+}
+func (m *mockBackend) Zone() string        { return m.zone }
+func (m *mockBackend) MaxConns() int64     { return m.maxConns }
+func (m *mockBackend) SetMaxConns(n int64) { m.maxConns = n }
+func (m *mockBackend) Acquire() bool {
+	if m.maxConns > 0 && m.conns >= m.maxConns {
+		return false
+	}
+	m.conns++
+	return true
+}
+func (m *mockBackend) ObserveLatency(d time.Duration) { m.latency = d }
+func (m *mockBackend) Latency() time.Duration         { return m.latency }
 
 func TestRoundRobin(t *testing.T) {
 	bs := []Backend{
-		&mockBackend{"b1", true, 0},
-		&mockBackend{"b2", true, 0},
-		&mockBackend{"b3", true, 0},
+		&mockBackend{u: "b1", alive: true},
+		&mockBackend{u: "b2", alive: true},
+		&mockBackend{u: "b3", alive: true},
 	}
 	rr := NewRoundRobin(bs)
 	order := []string{"b1", "b2", "b3", "b1", "b2", "b3", "b1"}
 	for _, want := range order {
-		b := rr.Next()
+		b := rr.Next(nil)
 		if b.URL().String() != want {
 			t.Errorf("got %s, want %s", b.URL(), want)
 		}
@@ -39,19 +83,408 @@ func TestRoundRobin(t *testing.T) {
 
 func TestLeastConnections(t *testing.T) {
 	bs := []Backend{
-		&mockBackend{"b1", true, 5},
-		&mockBackend{"b2", true, 2},
-		&mockBackend{"b3", true, 8},
+		&mockBackend{u: "b1", alive: true, conns: 5},
+		&mockBackend{u: "b2", alive: true, conns: 2},
+		&mockBackend{u: "b3", alive: true, conns: 8},
 	}
 	lc := NewLeastConnections(bs)
-	b := lc.Next()
+	b := lc.Next(nil)
 	if b.URL().String() != "b2" {
 		t.Errorf("got %s, want b2", b.URL())
 	}
 
 	bs[1].SetAlive(false)
-	b2 := lc.Next()
+	b2 := lc.Next(nil)
 	if b2.URL().String() != "b1" {
 		t.Errorf("got %s, want b1", b2.URL())
 	}
 }
+
+func TestWeightedRoundRobin(t *testing.T) {
+	bs := []Backend{
+		&mockBackend{u: "a", alive: true, weight: 5},
+		&mockBackend{u: "b", alive: true, weight: 1},
+		&mockBackend{u: "c", alive: true, weight: 1},
+	}
+	wrr := NewWeightedRoundRobin(bs)
+	// Плавное распределение: тяжелый сервер не получает 5 запросов подряд
+	order := []string{"a", "a", "b", "a", "c", "a", "a"}
+	for _, want := range order {
+		b := wrr.Next(nil)
+		if b.URL().String() != want {
+			t.Errorf("got %s, want %s", b.URL(), want)
+		}
+	}
+
+	bs[0].SetAlive(false)
+	counts := map[string]int{}
+	for i := 0; i != 10; i++ {
+		counts[wrr.Next(nil).URL().String()]++
+	}
+	if counts["a"] != 0 || counts["b"] != 5 || counts["c"] != 5 {
+		t.Errorf("unexpected distribution without a: %v", counts)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	var bs []Backend
+	for i := 0; i != 5; i++ {
+		bs = append(bs, &mockBackend{u: fmt.Sprintf("b%d", i), alive: true})
+	}
+	key, err := NewKeyFunc("header", "X-User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := NewConsistentHash(bs, key, 0)
+
+	req := func(user string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+		return r
+	}
+
+	// Один и тот же ключ всегда попадает на один сервер
+	before := map[string]string{}
+	for i := 0; i != 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		b := ch.Next(req(user)).URL().String()
+		if again := ch.Next(req(user)).URL().String(); again != b {
+			t.Fatalf("key %s moved from %s to %s", user, b, again)
+		}
+		before[user] = b
+	}
+
+	// Без одного сервера переезжают только его ключи
+	bs[2].SetAlive(false)
+	moved := 0
+	for user, was := range before {
+		now := ch.Next(req(user)).URL().String()
+		if now == "b2" {
+			t.Fatalf("key %s routed to dead backend", user)
+		}
+		if now != was {
+			if was != "b2" {
+				t.Errorf("key %s moved from alive backend %s", user, was)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 350 {
+		t.Errorf("expected ~1/5 keys to move, moved %d", moved)
+	}
+}
+
+func TestMaglev(t *testing.T) {
+	var bs []Backend
+	for i := 0; i != 5; i++ {
+		bs = append(bs, &mockBackend{u: fmt.Sprintf("b%d", i), alive: true})
+	}
+	key, err := NewKeyFunc("query", "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMaglev(bs, key, 1009)
+
+	req := func(id int) *http.Request {
+		return httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?id=%d", id), nil)
+	}
+
+	before := map[int]string{}
+	counts := map[string]int{}
+	for i := 0; i != 5000; i++ {
+		b := m.Next(req(i)).URL().String()
+		before[i] = b
+		counts[b]++
+	}
+	for name, n := range counts {
+		if n < 800 || n > 1200 {
+			t.Errorf("uneven distribution for %s: %d", name, n)
+		}
+	}
+
+	// После падения сервера таблица перестраивается, а ключи живых серверов почти не двигаются
+	bs[1].SetAlive(false)
+	moved := 0
+	for i, was := range before {
+		now := m.Next(req(i)).URL().String()
+		if now == "b1" {
+			t.Fatalf("key %d routed to dead backend", i)
+		}
+		if now != was && was != "b1" {
+			moved++
+		}
+	}
+	if moved > 250 {
+		t.Errorf("too many keys of alive backends moved: %d", moved)
+	}
+}
+
+func TestBoundedHash(t *testing.T) {
+	bs := []Backend{
+		&mockBackend{u: "b0", alive: true},
+		&mockBackend{u: "b1", alive: true},
+		&mockBackend{u: "b2", alive: true},
+	}
+	key, _ := NewKeyFunc("header", "X-User")
+	bh := NewBoundedHash(bs, key, 0, 1.25)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", "hot")
+	home := bh.Next(r)
+
+	// Горячий ключ занимает свой сервер, пока тот не превысит лимит
+	for i := 0; i != 30; i++ {
+		b := bh.Next(r)
+		b.Acquire()
+	}
+	const limit = 13 // ceil(1.25 * 30 / 3)
+	var total int64
+	for _, b := range bs {
+		total += b.Conns()
+		if b.Conns() > limit {
+			t.Errorf("%s has %d conns, limit %d", b.URL(), b.Conns(), limit)
+		}
+	}
+	if home.Conns() != limit || total != 30 {
+		t.Errorf("unexpected load: home=%d total=%d", home.Conns(), total)
+	}
+}
+
+func TestP2C(t *testing.T) {
+	bs := []Backend{
+		&mockBackend{u: "fast", alive: true, latency: 10 * time.Millisecond},
+		&mockBackend{u: "slow", alive: true, latency: 500 * time.Millisecond},
+	}
+	p := NewP2C(bs)
+	for i := 0; i != 20; i++ {
+		if b := p.Next(nil); b.URL().String() != "fast" {
+			t.Fatalf("got %s, want fast", b.URL())
+		}
+	}
+
+	// Много запросов в обработке перевешивает меньшую задержку
+	bs[0].(*mockBackend).conns = 100
+	if b := p.Next(nil); b.URL().String() != "slow" {
+		t.Errorf("got %s, want slow", b.URL())
+	}
+
+	bs[1].SetAlive(false)
+	if b := p.Next(nil); b.URL().String() != "fast" {
+		t.Errorf("got %s, want fast", b.URL())
+	}
+}
+
+func TestPeakEWMA(t *testing.T) {
+	var e peakEWMA
+	e.observe(10 * time.Millisecond)
+	e.observe(100 * time.Millisecond)
+	if got := e.load(); got < 99*time.Millisecond || got > 100*time.Millisecond {
+		t.Errorf("peak not taken immediately: %v", got)
+	}
+	e.observe(10 * time.Millisecond)
+	if got := e.load(); got <= 10*time.Millisecond || got > 100*time.Millisecond {
+		t.Errorf("expected smooth decrease, got %v", got)
+	}
+
+	// Устаревший пик затухает без новых замеров
+	e.observe(time.Second)
+	e.stamp = e.stamp.Add(-5 * ewmaDecay)
+	if got := e.load(); got > 10*time.Millisecond {
+		t.Errorf("expected stale peak to decay, got %v", got)
+	}
+}
+
+func TestTiered(t *testing.T) {
+	bs := []Backend{
+		&mockBackend{u: "p1", alive: true},
+		&mockBackend{u: "p2", alive: true},
+		&mockBackend{u: "p3", alive: true},
+		&mockBackend{u: "s1", alive: true, priority: 1},
+	}
+	sel := NewTiered(bs, 0.6, NewRoundRobin)
+
+	for i := 0; i != 6; i++ {
+		if b := sel.Next(nil); b.Priority() != 0 {
+			t.Fatalf("got %s from standby tier", b.URL())
+		}
+	}
+
+	// 2 из 3 живы - основная группа еще держит трафик
+	bs[0].SetAlive(false)
+	if b := sel.Next(nil); b.Priority() != 0 {
+		t.Errorf("got %s, want primary tier", b.URL())
+	}
+
+	// 1 из 3 - переключаемся на резерв
+	bs[1].SetAlive(false)
+	if b := sel.Next(nil); b.URL().String() != "s1" {
+		t.Errorf("got %s, want s1", b.URL())
+	}
+
+	// Резерв упал - используем остатки основной группы
+	bs[3].SetAlive(false)
+	if b := sel.Next(nil); b.URL().String() != "p3" {
+		t.Errorf("got %s, want p3", b.URL())
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	b, _ := NewBackend("http://b")
+	b.SetSlowStart(time.Hour)
+	if b.Ramp() != 1 {
+		t.Errorf("backend without recovery shouldn't ramp, got %v", b.Ramp())
+	}
+	b.SetAlive(false)
+	b.SetAlive(true)
+	if r := b.Ramp(); r < minRamp || r > minRamp+0.01 {
+		t.Errorf("recovered backend should start at %v, got %v", minRamp, r)
+	}
+
+	bs := []Backend{
+		&mockBackend{u: "warm", alive: true},
+		&mockBackend{u: "cold", alive: true, ramp: 0.25},
+	}
+	for name, sel := range map[string]Selector{
+		"round_robin":          NewRoundRobin(bs),
+		"random":               NewRandom(bs),
+		"weighted_round_robin": NewWeightedRoundRobin(bs),
+	} {
+		counts := map[string]int{}
+		for i := 0; i != 4000; i++ {
+			counts[sel.Next(nil).URL().String()]++
+		}
+		// Холодный сервер получает около 0.25/1.25 = 20% трафика
+		if c := counts["cold"]; c < 600 || c > 1000 {
+			t.Errorf("%s: cold backend got %d of 4000", name, c)
+		}
+	}
+}
+
+func TestZoneAware(t *testing.T) {
+	bs := []Backend{
+		&mockBackend{u: "a1", alive: true, zone: "a"},
+		&mockBackend{u: "a2", alive: true, zone: "a"},
+		&mockBackend{u: "b1", alive: true, zone: "b"},
+	}
+	sel := NewZoneAware(bs, "a", 0.6, NewRoundRobin)
+
+	for i := 0; i != 6; i++ {
+		if b := sel.Next(nil); b.Zone() != "a" {
+			t.Fatalf("got %s from remote zone", b.URL())
+		}
+	}
+
+	// Половина локальных серверов упала - трафик распределяется по всему пулу
+	bs[0].SetAlive(false)
+	counts := map[string]int{}
+	for i := 0; i != 4; i++ {
+		counts[sel.Next(nil).URL().String()]++
+	}
+	if counts["a2"] != 2 || counts["b1"] != 2 {
+		t.Errorf("expected spill to remote zone, got %v", counts)
+	}
+}
+
+func TestPreferred(t *testing.T) {
+	bs := []Backend{
+		&mockBackend{u: "a1", alive: true, zone: "a"},
+		&mockBackend{u: "b1", alive: true, zone: "b"},
+		&mockBackend{u: "a2", alive: true, zone: "a", priority: 1},
+	}
+	pool, err := NewPool(bs, func(bs []Backend) Selector {
+		return NewTiered(bs, 0.5, func(g []Backend) Selector { return NewZoneAware(g, "a", 0.5, NewRoundRobin) })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Preferred(pool)
+	if len(got) != 2 || len(got[0]) != 1 || got[0][0] != bs[0] || len(got[1]) != 2 {
+		t.Fatalf("expected local zone then active tier, got %v", got)
+	}
+
+	// Своя зона пуста - остается только активная группа
+	bs[0].SetAlive(false)
+	if got := Preferred(pool); len(got) != 1 || len(got[0]) != 2 {
+		t.Errorf("expected only active tier, got %v", got)
+	}
+
+	if got := Preferred(NewRoundRobin(bs)); got != nil {
+		t.Errorf("expected no preference for plain selector, got %v", got)
+	}
+}
+
+func TestPool(t *testing.T) {
+	b1 := &mockBackend{u: "b1", alive: true}
+	b2 := &mockBackend{u: "b2", alive: true}
+	pool, err := NewPool([]Backend{b1, b2}, NewRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPool([]Backend{b1, b1}, NewRoundRobin); err == nil {
+		t.Error("expected error for duplicate names")
+	}
+
+	var notified []Backend
+	pool.OnChange(func(bs []Backend) { notified = bs })
+
+	if err := pool.Add(&mockBackend{u: "b3", alive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(&mockBackend{u: "b3", alive: true}); err == nil {
+		t.Error("expected error for duplicate backend")
+	}
+	if len(notified) != 3 {
+		t.Errorf("listener got %d backends, want 3", len(notified))
+	}
+
+	if _, err := pool.Remove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Remove("b1"); err == nil {
+		t.Error("expected error for missing backend")
+	}
+	for i := 0; i != 4; i++ {
+		if b := pool.Next(nil); b == Backend(b1) {
+			t.Fatal("removed backend selected")
+		}
+	}
+	if pool.Get("b3") == nil || pool.Get("b1") != nil || len(pool.List()) != 2 {
+		t.Errorf("unexpected pool state: %v", pool.List())
+	}
+}
+
+func TestDraining(t *testing.T) {
+	bs := []Backend{
+		&mockBackend{u: "b1", alive: true},
+		&mockBackend{u: "b2", alive: true},
+	}
+	key, _ := NewKeyFunc("", "")
+	for name, sel := range map[string]Selector{
+		"round_robin": NewRoundRobin(bs),
+		"least_conn":  NewLeastConnections(bs),
+		"maglev":      NewMaglev(bs, key, 101),
+	} {
+		bs[0].SetDraining(true)
+		for i := 0; i != 10; i++ {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i)
+			if b := sel.Next(r); b.URL().String() != "b2" {
+				t.Errorf("%s: got draining backend %s", name, b.URL())
+			}
+		}
+		bs[0].SetDraining(false)
+	}
+}
+
+func TestNewFromSpec_URL(t *testing.T) {
+	for _, raw := range []string{"foo", "10.0.0.1:8080", "ftp://10.0.0.1", "http://", "/path"} {
+		if _, err := NewFromSpec(Spec{Name: "b", URL: raw}); err == nil {
+			t.Errorf("expected error for url %q", raw)
+		}
+	}
+	for _, raw := range []string{"http://10.0.0.1:8080", "https://backend.local"} {
+		if _, err := NewFromSpec(Spec{Name: "b", URL: raw}); err != nil {
+			t.Errorf("url %q: %v", raw, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package loadbalancer
+
+import "math/rand"
+
+// Доля веса, с которой начинается прогрев сервера
+const minRamp = 0.1
+
+// Решает, отдать ли запрос серверу с учетом прогрева:
+// сервер на прогреве принимается с вероятностью, равной его доле веса
+func admit(b Backend) bool {
+	f := b.Ramp()
+	return f >= 1 || rand.Float64() < f
+}
@@ -0,0 +1,116 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"loadbalancer/internal/logging"
+)
+
+// Создает селектор для списка серверов
+type Factory func(bs []Backend) Selector
+
+// Группа серверов с одинаковым приоритетом
+type tier struct {
+	priority int
+	backends []Backend
+	sel      Selector
+}
+
+// tiered распределяет запросы по группам приоритетов: используется самая
+// приоритетная группа, в которой доля живых серверов не ниже minHealthy
+type tiered struct {
+	tiers      []tier
+	minHealthy float64
+
+	mu      sync.Mutex
+	version uint64 // Версия состояния пула, для которой выбрана группа
+	active  int    // Индекс активной группы, -1 если живых нет
+}
+
+// Возвращает селектор с группами приоритетов. Внутри группы сервер выбирает
+// селектор, созданный factory. Если группа одна, возвращается ее селектор
+func NewTiered(bs []Backend, minHealthy float64, factory Factory) Selector {
+	groups := map[int][]Backend{}
+	for _, b := range bs {
+		groups[b.Priority()] = append(groups[b.Priority()], b)
+	}
+	if len(groups) <= 1 {
+		return factory(bs)
+	}
+
+	t := &tiered{minHealthy: minHealthy, active: -1}
+	for p, g := range groups {
+		t.tiers = append(t.tiers, tier{priority: p, backends: g, sel: factory(g)})
+	}
+	slices.SortFunc(t.tiers, func(a, b tier) int { return a.priority - b.priority })
+	t.version = stateVersion.Load()
+	t.active = t.choose()
+	return t
+}
+
+// Next выбирает сервер в активной группе
+func (t *tiered) Next(r *http.Request) Backend {
+	active := t.current()
+	if active == -1 {
+		return nil
+	}
+	return t.tiers[active].sel.Next(r)
+}
+
+// Возвращает индекс активной группы, пересчитывая его после изменения состояния пула
+func (t *tiered) current() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if v := stateVersion.Load(); v != t.version {
+		t.version = v
+		if active := t.choose(); active != t.active {
+			t.logTransition(active)
+			t.active = active
+		}
+	}
+	return t.active
+}
+
+// Выбор сужается до активной группы
+func (t *tiered) narrow() (Selector, []Backend) {
+	active := t.current()
+	if active == -1 {
+		return nil, nil
+	}
+	return t.tiers[active].sel, t.tiers[active].backends
+}
+
+// Возвращает индекс первой группы с достаточной долей живых серверов.
+// Если такой нет, то первой группы, где есть хоть один живой
+func (t *tiered) choose() int {
+	fallback := -1
+	for i, tr := range t.tiers {
+		share := aliveShare(tr.backends)
+		if share == 0 {
+			continue
+		}
+		if share >= t.minHealthy {
+			return i
+		}
+		if fallback == -1 {
+			fallback = i
+		}
+	}
+	return fallback
+}
+
+// Логирует переход на другую группу
+func (t *tiered) logTransition(to int) {
+	if to == -1 {
+		logging.L.Error("no priority tier has alive backends")
+		return
+	}
+	from := "none"
+	if t.active != -1 {
+		from = fmt.Sprint(t.tiers[t.active].priority)
+	}
+	logging.L.Warn("priority tier changed", "from", from, "to", t.tiers[to].priority)
+}
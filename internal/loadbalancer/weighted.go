@@ -0,0 +1,50 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"sync"
+)
+
+// weightedRoundRobin реализует плавный взвешенный round-robin (как в nginx)
+type weightedRoundRobin struct {
+	backends []Backend
+	mu       sync.Mutex
+	current  []float64 // Текущий вес каждого сервера
+}
+
+// Возвращает селектор, распределяющий запросы пропорционально весам
+func NewWeightedRoundRobin(bs []Backend) Selector {
+	return &weightedRoundRobin{backends: bs, current: make([]float64, len(bs))}
+}
+
+// Next выбирает живой backend с наибольшим текущим весом.
+// На каждом шаге текущий вес увеличивается на вес сервера, а у выбранного
+// уменьшается на сумму весов, поэтому запросы не идут пачкой на один сервер.
+// Вес сервера на прогреве уменьшается пропорционально его доле
+func (w *weightedRoundRobin) Next(*http.Request) Backend {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var total float64
+	best := -1
+	for i, b := range w.backends {
+		if !b.Alive() {
+			w.current[i] = 0 // Мертвый сервер начинает заново после восстановления
+			continue
+		}
+		if !Available(b) {
+			continue
+		}
+		weight := float64(b.Weight()) * b.Ramp()
+		w.current[i] += weight
+		total += weight
+		if best == -1 || w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	w.current[best] -= total
+	return w.backends[best]
+}
@@ -0,0 +1,84 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"sync"
+
+	"loadbalancer/internal/logging"
+)
+
+// zoneAware предпочитает серверы из локальной зоны и переходит на весь пул,
+// только когда доля живых локальных серверов падает ниже minHealthy
+type zoneAware struct {
+	zone       string
+	local      []Backend
+	localSel   Selector // Выбор среди серверов своей зоны
+	allSel     Selector // Выбор среди всех серверов
+	minHealthy float64
+
+	mu      sync.Mutex
+	version uint64 // Версия состояния пула, для которой принято решение
+	spill   bool   // Трафик уходит в другие зоны
+}
+
+// Возвращает селектор с учетом зон. Если в пуле нет серверов своей зоны
+// или все серверы в ней, возвращается обычный селектор
+func NewZoneAware(bs []Backend, zone string, minHealthy float64, factory Factory) Selector {
+	var local []Backend
+	for _, b := range bs {
+		if b.Zone() == zone {
+			local = append(local, b)
+		}
+	}
+	if zone == "" || len(local) == 0 || len(local) == len(bs) {
+		return factory(bs)
+	}
+
+	z := &zoneAware{
+		zone:       zone,
+		local:      local,
+		localSel:   factory(local),
+		allSel:     factory(bs),
+		minHealthy: minHealthy,
+		version:    stateVersion.Load(),
+	}
+	z.spill = aliveShare(local) < minHealthy
+	return z
+}
+
+// Next выбирает сервер своей зоны, а при нехватке живых локальных серверов - из всего пула
+func (z *zoneAware) Next(r *http.Request) Backend {
+	if !z.spilling() {
+		// Все локальные серверы могут быть заняты - тогда берем из всего пула
+		if b := z.localSel.Next(r); b != nil {
+			return b
+		}
+	}
+	return z.allSel.Next(r)
+}
+
+// Сообщает, уходит ли трафик в другие зоны, пересчитывая это после изменения состояния пула
+func (z *zoneAware) spilling() bool {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if v := stateVersion.Load(); v != z.version {
+		z.version = v
+		if spill := aliveShare(z.local) < z.minHealthy; spill != z.spill {
+			z.spill = spill
+			if spill {
+				logging.L.Warn("local zone capacity low, spilling to other zones", "zone", z.zone)
+			} else {
+				logging.L.Info("local zone capacity restored", "zone", z.zone)
+			}
+		}
+	}
+	return z.spill
+}
+
+// Выбор сужается до своей зоны, пока в ней достаточно живых серверов
+func (z *zoneAware) narrow() (Selector, []Backend) {
+	if z.spilling() {
+		return nil, nil
+	}
+	return z.localSel, z.local
+}
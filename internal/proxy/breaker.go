@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+	"loadbalancer/internal/logging"
+)
+
+// Состояния автомата
+const (
+	BreakerClosed   = "closed"    // Запросы проходят
+	BreakerOpen     = "open"      // Сервер исключен до окончания таймаута
+	BreakerHalfOpen = "half_open" // Пропускается ограниченное число пробных запросов
+)
+
+// Параметры автомата защиты серверов
+type BreakerConfig struct {
+	ConsecutiveFailures int           // Ошибок подряд для размыкания, 0 - не учитывать
+	FailureRatio        float64       // Доля ошибок за окно для размыкания, 0 - не учитывать
+	MinRequests         int           // Минимум запросов в окне для проверки доли ошибок
+	Window              time.Duration // Окно подсчета доли ошибок
+	OpenTimeout         time.Duration // Сколько автомат остается разомкнутым
+	HalfOpenRequests    int           // Пробных запросов в полуоткрытом состоянии
+}
+
+// Состояние автомата для API
+type BreakerStatus struct {
+	State    string    `json:"state"`
+	Failures int       `json:"consecutive_failures"`
+	Requests int       `json:"window_requests"`
+	Errors   int       `json:"window_errors"`
+	OpenedAt time.Time `json:"opened_at,omitzero"`
+	Trips    int       `json:"trips"`
+}
+
+// Автомат одного сервера
+type breaker struct {
+	state    string
+	failures int       // Ошибок подряд
+	requests int       // Запросов в текущем окне
+	errors   int       // Ошибок в текущем окне
+	windowAt time.Time // Начало текущего окна
+	openedAt time.Time // Время последнего размыкания
+	trips    int       // Сколько раз автомат размыкался
+	trials   int       // Пробных запросов в работе
+	passed   int       // Успешных пробных запросов
+}
+
+// breakers хранит автоматы серверов пула. Разомкнутый автомат исключает
+// сервер из выбора, по таймауту пропускает пробные запросы и по их
+// результату замыкается или снова размыкается
+type breakers struct {
+	cfg BreakerConfig
+
+	mu sync.Mutex
+	m  map[loadbalancer.Backend]*breaker
+}
+
+// Создает автоматы, незаданные параметры заменяются значениями по умолчанию
+func newBreakers(cfg BreakerConfig) *breakers {
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &breakers{cfg: cfg, m: map[loadbalancer.Backend]*breaker{}}
+}
+
+// Удаляет автоматы серверов, которых больше нет в пуле
+func (bs *breakers) update(list []loadbalancer.Backend) {
+	keep := make(map[loadbalancer.Backend]bool, len(list))
+	for _, b := range list {
+		keep[b] = true
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for b := range bs.m {
+		if !keep[b] {
+			b.SetTripped(false)
+			delete(bs.m, b)
+		}
+	}
+}
+
+// Возвращает автомат сервера. Вызывается под mu
+func (bs *breakers) get(b loadbalancer.Backend) *breaker {
+	br, ok := bs.m[b]
+	if !ok {
+		br = &breaker{state: BreakerClosed, windowAt: time.Now()}
+		bs.m[b] = br
+	}
+	return br
+}
+
+// Сообщает, можно ли отправить запрос на сервер. В полуоткрытом
+// состоянии занимает место пробного запроса
+func (bs *breakers) allow(b loadbalancer.Backend) bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	br := bs.get(b)
+	switch br.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if br.trials+br.passed >= bs.cfg.HalfOpenRequests {
+			return false
+		}
+		br.trials++
+	}
+	return true
+}
+
+// Учитывает результат запроса к серверу
+func (bs *breakers) record(b loadbalancer.Backend, failed bool) {
+	now := time.Now()
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	br := bs.get(b)
+
+	switch br.state {
+	case BreakerOpen:
+		// Запрос начался до размыкания
+		return
+
+	case BreakerHalfOpen:
+		br.trials = max(br.trials-1, 0)
+		if failed {
+			bs.open(b, br, now, "trial request failed")
+			return
+		}
+		br.passed++
+		if br.passed >= bs.cfg.HalfOpenRequests {
+			*br = breaker{state: BreakerClosed, windowAt: now, trips: br.trips, openedAt: br.openedAt}
+			logging.L.Info("circuit breaker closed", "backend", b.URL().String())
+		}
+		return
+	}
+
+	if now.Sub(br.windowAt) > bs.cfg.Window {
+		br.windowAt, br.requests, br.errors = now, 0, 0
+	}
+	br.requests++
+	if !failed {
+		br.failures = 0
+		return
+	}
+	br.failures++
+	br.errors++
+
+	if c := bs.cfg.ConsecutiveFailures; c > 0 && br.failures >= c {
+		bs.open(b, br, now, "consecutive failures")
+		return
+	}
+	if bs.cfg.FailureRatio > 0 && br.requests >= bs.cfg.MinRequests &&
+		float64(br.errors)/float64(br.requests) >= bs.cfg.FailureRatio {
+		bs.open(b, br, now, "failure ratio")
+	}
+}
+
+// Возвращает место пробного запроса, если запрос завершился без результата,
+// например отменен после ответа дубля или уходом клиента
+func (bs *breakers) cancel(b loadbalancer.Backend) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if br, ok := bs.m[b]; ok && br.state == BreakerHalfOpen {
+		br.trials = max(br.trials-1, 0)
+	}
+}
+
+// Размыкает автомат и исключает сервер до окончания таймаута. Вызывается под mu
+func (bs *breakers) open(b loadbalancer.Backend, br *breaker, now time.Time, reason string) {
+	br.state = BreakerOpen
+	br.openedAt = now
+	br.trips++
+	br.failures, br.requests, br.errors, br.trials, br.passed = 0, 0, 0, 0, 0
+	b.SetTripped(true)
+	logging.L.Warn("circuit breaker opened", "backend", b.URL().String(), "reason", reason, "trips", br.trips)
+
+	opened := br.openedAt
+	time.AfterFunc(bs.cfg.OpenTimeout, func() { bs.halfOpen(b, opened) })
+}
+
+// Переводит автомат в полуоткрытое состояние и возвращает сервер в выбор.
+// Живость сервера по health check не меняется
+func (bs *breakers) halfOpen(b loadbalancer.Backend, opened time.Time) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	br, ok := bs.m[b]
+	// Сервер удален из пула или автомат уже размыкался заново
+	if !ok || br.state != BreakerOpen || !br.openedAt.Equal(opened) {
+		return
+	}
+	br.state = BreakerHalfOpen
+	b.SetTripped(false)
+	logging.L.Info("circuit breaker half-open", "backend", b.URL().String())
+}
+
+// Возвращает состояние автомата сервера
+func (bs *breakers) status(b loadbalancer.Backend) BreakerStatus {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	br := bs.get(b)
+	return BreakerStatus{
+		State:    br.state,
+		Failures: br.failures,
+		Requests: br.requests,
+		Errors:   br.errors,
+		OpenedAt: br.openedAt,
+		Trips:    br.trips,
+	}
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+	"loadbalancer/internal/logging"
+)
+
+// Ответ уже получен от другого сервера, ответ этой попытки отбрасывается
+var errHedgeLost = errors.New("hedged request lost")
+
+// Сколько последних задержек учитывается для перцентиля
+const latencySamples = 512
+
+// Параметры дублирования запросов
+type HedgePolicy struct {
+	Delay      time.Duration // Через сколько отправлять дубль; при Percentile - пока нет статистики
+	Percentile float64       // Перцентиль наблюдаемой задержки как задержка дубля, 0 - только Delay
+	MaxRate    float64       // Максимальная доля дублированных запросов
+}
+
+// Включает дублирование идемпотентных запросов: если сервер не ответил за
+// задержку, тот же запрос отправляется на второй сервер, используется
+// первый полученный ответ, а второй запрос отменяется
+func WithHedging(policy HedgePolicy) Option {
+	return func(p *Proxy) {
+		if policy.Delay <= 0 && policy.Percentile <= 0 {
+			return
+		}
+		if policy.MaxRate <= 0 {
+			policy.MaxRate = 0.1
+		}
+		p.hedging = &hedging{policy: policy, window: time.Now()}
+	}
+}
+
+// hedging считает задержки ответов и ограничивает долю дублированных запросов
+type hedging struct {
+	policy HedgePolicy
+
+	mu       sync.Mutex
+	samples  [latencySamples]time.Duration
+	n        int          // Всего наблюдений
+	delay    atomic.Int64 // Текущая задержка по перцентилю
+	window   time.Time    // Начало окна подсчета доли
+	requests int          // Запросов в окне
+	hedges   int          // Дублей в окне
+}
+
+// Окно подсчета доли дублированных запросов
+const hedgeWindow = 10 * time.Second
+
+// Запоминает задержку ответа и раз в 64 наблюдения пересчитывает перцентиль
+func (h *hedging) observe(d time.Duration) {
+	if h.policy.Percentile <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples[h.n%latencySamples] = d
+	h.n++
+	if h.n%64 != 0 {
+		return
+	}
+	sorted := slices.Clone(h.samples[:min(h.n, latencySamples)])
+	slices.Sort(sorted)
+	i := int(float64(len(sorted)-1) * h.policy.Percentile / 100)
+	h.delay.Store(int64(sorted[i]))
+}
+
+// Возвращает задержку перед отправкой дубля
+func (h *hedging) after() time.Duration {
+	if d := time.Duration(h.delay.Load()); d > 0 {
+		return d
+	}
+	if h.policy.Delay > 0 {
+		return h.policy.Delay
+	}
+	// Статистики еще нет, а фиксированная задержка не задана
+	return time.Second
+}
+
+// Учитывает запрос, который можно дублировать
+func (h *hedging) request() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.window) > hedgeWindow {
+		h.window, h.requests, h.hedges = time.Now(), 0, 0
+	}
+	h.requests++
+}
+
+// Сообщает, можно ли отправить дубль без превышения доли MaxRate
+func (h *hedging) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if float64(h.hedges+1) > h.policy.MaxRate*float64(h.requests) {
+		return false
+	}
+	h.hedges++
+	return true
+}
+
+// Гонка попыток одного запроса: побеждает первая получившая заголовки ответа
+type hedgeRace struct {
+	mu      sync.Mutex
+	winner  *attemptState
+	cancels []context.CancelFunc
+}
+
+// Отмечает попытку победителем, если его еще нет, и отменяет остальные
+func (hr *hedgeRace) claim(st *attemptState) bool {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if hr.winner != nil {
+		return false
+	}
+	hr.winner = st
+	for _, cancel := range hr.cancels {
+		cancel()
+	}
+	return true
+}
+
+// Сообщает, есть ли победитель
+func (hr *hedgeRace) won() bool {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return hr.winner != nil
+}
+
+// Отправляет запрос на последний сервер из tried и, если он не ответил за
+// задержку, его дубль на другой сервер. Используется ответ, заголовки которого
+// пришли первыми. Если первая попытка завершилась ошибкой до задержки, дубль
+// отправляется сразу. Сервер дубля добавляется в tried. Возвращает true, если
+// ни одна попытка не дала ответа и запрос можно повторить, retryable сообщает,
+// можно ли повторять попытку с данным номером
+func (p *Proxy) hedge(w *trackingWriter, r *http.Request, tried *[]loadbalancer.Backend, body []byte, retryable func(attempt int) bool) bool {
+	race := &hedgeRace{}
+	finished := make(chan struct{}, 2)
+	var wg sync.WaitGroup
+	var states []*attemptState
+	run := func(b loadbalancer.Backend, hedged bool) {
+		ctx, cancel := context.WithCancel(r.Context())
+		st := &attemptState{
+			b:         b,
+			w:         w,
+			client:    r.Context(),
+			start:     time.Now(),
+			retryable: retryable(len(*tried)),
+			race:      race,
+			hedged:    hedged,
+		}
+		states = append(states, st)
+		// Победившая попытка не отменяется, ее ответ передается клиенту
+		race.mu.Lock()
+		race.cancels = append(race.cancels, func() {
+			if race.winner != st {
+				cancel()
+			}
+		})
+		race.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			p.send(st, r.Clone(ctx), body) // Заголовки у каждой попытки свои
+			finished <- struct{}{}
+		}()
+	}
+
+	p.hedging.request()
+	b := (*tried)[len(*tried)-1]
+	run(b, false)
+
+	timer := time.NewTimer(p.hedging.after())
+	defer timer.Stop()
+	second := false
+	select {
+	case <-timer.C:
+		second = !race.won() && p.hedging.allow()
+	case <-finished:
+		// Первая попытка завершилась без ответа, дубль работает как повтор
+		// и отправляется, только если политика разрешает повтор
+		second = !race.won() && states[0].retry
+	case <-r.Context().Done():
+	}
+
+	// Уже опробованные серверы пропускаются, поэтому при hash-алгоритмах
+	// дубль уходит на другой сервер, а не на тот же
+	if second {
+		if b2 := p.pick(nil, r, false, *tried); b2 != nil {
+			logging.L.Info("hedged request", "backend", b2.URL().String())
+			*tried = append(*tried, b2)
+			run(b2, true)
+		}
+	}
+
+	wg.Wait()
+	if race.won() || w.wrote || r.Context().Err() != nil {
+		return false
+	}
+	retry := true
+	for _, st := range states {
+		retry = retry && st.retry
+	}
+	if !retry {
+		logging.L.Error("all backends failed")
+		http.Error(w, "all backends failed", http.StatusBadGateway)
+	}
+	return retry
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+	"loadbalancer/internal/logging"
+)
+
+// Количество интервалов, на которые делится окно подсчета ошибок
+const outlierBuckets = 10
+
+// Параметры пассивной проверки серверов по живому трафику
+type OutlierConfig struct {
+	Consecutive5xx           int           // Ответов 5xx подряд для исключения, 0 - не учитывать
+	ConsecutiveGatewayErrors int           // Ответов 502/503/504 и ошибок соединения подряд, 0 - не учитывать
+	ErrorRate                float64       // Доля ошибок в окне для исключения, 0 - не учитывать
+	MinRequests              int           // Минимум запросов в окне для проверки доли ошибок
+	Window                   time.Duration // Окно подсчета доли ошибок
+	BaseEjection             time.Duration // Базовое время исключения, растет с каждым исключением
+	MaxEjection              time.Duration // Максимальное время исключения
+	MaxEjectedPercent        int           // Максимальный процент исключенных серверов пула
+}
+
+// Счетчики запросов за один интервал окна
+type bucket struct {
+	start  int64 // Номер интервала
+	total  int
+	errors int
+}
+
+// Состояние пассивной проверки одного сервера
+type outlierState struct {
+	consecutive5xx     int
+	consecutiveGateway int
+	buckets            [outlierBuckets]bucket
+
+	ejected   bool      // Сервер исключен детектором
+	until     time.Time // До какого момента сервер нельзя вернуть
+	ejections int       // Сколько раз подряд сервер исключался
+}
+
+// outlier исключает серверы, которые возвращают ошибки на живом трафике.
+// Возвращает их активный health check, но не раньше окончания времени исключения
+type outlier struct {
+	cfg OutlierConfig
+
+	mu     sync.Mutex
+	total  int // Количество серверов в пуле
+	states map[loadbalancer.Backend]*outlierState
+}
+
+// Создает детектор, незаданные параметры заменяются значениями по умолчанию
+func newOutlier(cfg OutlierConfig, bs []loadbalancer.Backend) *outlier {
+	if cfg.Window <= 0 {
+		cfg.Window = 30 * time.Second
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.BaseEjection <= 0 {
+		cfg.BaseEjection = 30 * time.Second
+	}
+	if cfg.MaxEjection < cfg.BaseEjection {
+		cfg.MaxEjection = max(5*time.Minute, cfg.BaseEjection)
+	}
+	if cfg.MaxEjectedPercent <= 0 {
+		cfg.MaxEjectedPercent = 10
+	}
+	o := &outlier{cfg: cfg, states: map[loadbalancer.Backend]*outlierState{}}
+	o.update(bs)
+	return o
+}
+
+// Обновляет список серверов после изменения пула
+func (o *outlier) update(bs []loadbalancer.Backend) {
+	keep := make(map[loadbalancer.Backend]bool, len(bs))
+	for _, b := range bs {
+		keep[b] = true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.total = len(bs)
+	for b := range o.states {
+		if !keep[b] {
+			delete(o.states, b)
+		}
+	}
+}
+
+// Возвращает состояние сервера. Вызывается под mu
+func (o *outlier) state(b loadbalancer.Backend) *outlierState {
+	st, ok := o.states[b]
+	if !ok {
+		st = &outlierState{}
+		o.states[b] = st
+	}
+	return st
+}
+
+// Учитывает результат запроса: код ответа или 0 при ошибке соединения
+func (o *outlier) observe(b loadbalancer.Backend, status int) {
+	now := time.Now()
+	failed := status == 0 || status >= 500
+	gateway := status == 0 || status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	st := o.state(b)
+
+	if failed && status != 0 {
+		st.consecutive5xx++
+	} else if !failed {
+		st.consecutive5xx = 0
+	}
+	if gateway {
+		st.consecutiveGateway++
+	} else {
+		st.consecutiveGateway = 0
+	}
+
+	// Интервал окна, в который попадает запрос
+	n := now.UnixNano() / int64(o.cfg.Window/outlierBuckets)
+	bk := &st.buckets[n%outlierBuckets]
+	if bk.start != n {
+		*bk = bucket{start: n}
+	}
+	bk.total++
+	if failed {
+		bk.errors++
+	}
+
+	if reason := o.reason(st, n); reason != "" {
+		o.eject(b, st, now, reason)
+	}
+}
+
+// Возвращает причину исключения сервера или пустую строку
+func (o *outlier) reason(st *outlierState, n int64) string {
+	if c := o.cfg.Consecutive5xx; c > 0 && st.consecutive5xx >= c {
+		return "consecutive 5xx"
+	}
+	if c := o.cfg.ConsecutiveGatewayErrors; c > 0 && st.consecutiveGateway >= c {
+		return "consecutive gateway errors"
+	}
+	if o.cfg.ErrorRate > 0 {
+		total, errors := 0, 0
+		for _, bk := range st.buckets {
+			if n-bk.start < outlierBuckets {
+				total += bk.total
+				errors += bk.errors
+			}
+		}
+		if total >= o.cfg.MinRequests && float64(errors)/float64(total) >= o.cfg.ErrorRate {
+			return "error rate"
+		}
+	}
+	return ""
+}
+
+// Исключает сервер, если не превышен лимит исключенных. Вызывается под mu
+func (o *outlier) eject(b loadbalancer.Backend, st *outlierState, now time.Time, reason string) {
+	if o.isEjected(b, st, now) || !b.Alive() {
+		return
+	}
+	ejected := 0
+	for eb, s := range o.states {
+		if o.isEjected(eb, s, now) {
+			ejected++
+		}
+	}
+	// Один сервер можно исключить всегда, иначе маленький пул не защищен
+	if limit := max(1, o.total*o.cfg.MaxEjectedPercent/100); ejected >= limit {
+		logging.L.Warn("outlier ejection skipped, too many ejected", "backend", b.URL().String(), "ejected", ejected)
+		return
+	}
+
+	// Счетчик исключений сбрасывается, если сервер долго работал без ошибок
+	if now.Sub(st.until) > o.cfg.MaxEjection {
+		st.ejections = 0
+	}
+	st.ejections++
+	d := min(o.cfg.BaseEjection*time.Duration(st.ejections), o.cfg.MaxEjection)
+	st.ejected = true
+	st.until = now.Add(d)
+	st.consecutive5xx, st.consecutiveGateway = 0, 0
+	st.buckets = [outlierBuckets]bucket{}
+
+	b.SetAlive(false)
+	logging.L.Warn("backend ejected", "backend", b.URL().String(), "reason", reason, "duration", d, "ejections", st.ejections)
+}
+
+// Сообщает, исключен ли сервер: до окончания времени исключения
+// и далее, пока health check не вернет его в работу. Вызывается под mu
+func (o *outlier) isEjected(b loadbalancer.Backend, st *outlierState, now time.Time) bool {
+	if !st.ejected {
+		return false
+	}
+	if now.Before(st.until) || !b.Alive() {
+		return true
+	}
+	st.ejected = false
+	return false
+}
+
+// Сообщает, что сервер нельзя возвращать в работу до окончания времени исключения
+func (o *outlier) held(b loadbalancer.Backend) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	st, ok := o.states[b]
+	return ok && st.ejected && time.Now().Before(st.until)
+}
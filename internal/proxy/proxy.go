@@ -1,8 +1,16 @@
 package proxy
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httputil"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"loadbalancer/internal/loadbalancer"
 	"loadbalancer/internal/logging"
@@ -10,60 +18,396 @@ import (
 
 // Инкапсулирует выбор серверов
 type Proxy struct {
-	sel loadbalancer.Selector // Алгоритм выбора
+	sel       loadbalancer.Selector // Алгоритм выбора
+	sticky    *sticky               // Липкие сессии, nil если выключены
+	queue     *queue                // Очередь запросов при занятых серверах, nil если выключена
+	outlier   *outlier              // Пассивная проверка серверов, nil если выключена
+	breakers  *breakers             // Автоматы защиты серверов, nil если выключены
+	retry     RetryPolicy           // Политика повторных попыток
+	upstreams upstreams             // Reverse proxy и пулы соединений серверов
+	hedging   *hedging              // Дублирование запросов, nil если выключено
+	backends  atomic.Pointer[[]loadbalancer.Backend]
 }
 
-// Создание нового Proxy с выбранным алгоритмом
-func New(sel loadbalancer.Selector) *Proxy {
-	return &Proxy{sel: sel}
+// Дополнительная настройка Proxy
+type Option func(*Proxy)
+
+// Включает липкие сессии: клиент получает подписанную cookie и дальше
+// попадает на тот же сервер, пока он жив
+func WithSticky(bs []loadbalancer.Backend, cookie string, secret []byte, ttl time.Duration) Option {
+	return func(p *Proxy) {
+		p.sticky = newSticky(bs, cookie, secret, ttl)
+	}
+}
+
+// Включает очередь: если все серверы достигли лимита соединений, запрос ждет
+// освобождения до timeout, а при заполненной очереди сразу получает 503
+func WithQueue(bs []loadbalancer.Backend, size int, timeout time.Duration) Option {
+	return func(p *Proxy) {
+		if size > 0 {
+			p.queue = newQueue(bs, size, timeout)
+		}
+	}
+}
+
+// Включает пассивную проверку серверов: сервер, который возвращает ошибки
+// на живом трафике, исключается на растущее время. Без нее сервер
+// исключается при первой же ошибке соединения
+func WithOutlierDetection(bs []loadbalancer.Backend, cfg OutlierConfig) Option {
+	return func(p *Proxy) {
+		p.outlier = newOutlier(cfg, bs)
+	}
+}
+
+// Включает автомат защиты для каждого сервера: после серии ошибок сервер
+// исключается на OpenTimeout, затем получает несколько пробных запросов
+// и по их результату возвращается в работу или снова исключается
+func WithCircuitBreaker(cfg BreakerConfig) Option {
+	return func(p *Proxy) {
+		p.breakers = newBreakers(cfg)
+	}
+}
+
+// Создание нового Proxy с выбранным алгоритмом. bs - серверы пула,
+// при изменении пула список обновляется через SetBackends
+func New(sel loadbalancer.Selector, bs []loadbalancer.Backend, opts ...Option) *Proxy {
+	p := &Proxy{sel: sel, retry: defaultRetry, upstreams: upstreams{cfg: defaultTransport}}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.SetBackends(bs)
+	return p
+}
+
+// Обновляет список серверов после изменения пула
+func (p *Proxy) SetBackends(bs []loadbalancer.Backend) {
+	p.backends.Store(&bs)
+	if p.sticky != nil {
+		p.sticky.update(bs)
+	}
+	if p.outlier != nil {
+		p.outlier.update(bs)
+	}
+	if p.breakers != nil {
+		p.breakers.update(bs)
+	}
+	if p.queue != nil {
+		p.queue.update(bs)
+	}
+	p.upstreams.update(bs)
+}
+
+// Сообщает, что сервер исключен пассивной проверкой и еще не может
+// вернуться в работу. Используется health check перед восстановлением.
+// Автомат защиты исключает сервер отдельным флагом и здесь не учитывается
+func (p *Proxy) Ejected(b loadbalancer.Backend) bool {
+	return p.outlier != nil && p.outlier.held(b)
+}
+
+// Возвращает состояние автомата защиты сервера, false если автоматы выключены
+func (p *Proxy) Breaker(b loadbalancer.Backend) (BreakerStatus, bool) {
+	if p.breakers == nil {
+		return BreakerStatus{}, false
+	}
+	return p.breakers.status(b), true
+}
+
+// Возвращает количество запросов, ожидающих в очереди
+func (p *Proxy) QueueDepth() int {
+	if p.queue == nil {
+		return 0
+	}
+	return p.queue.depth()
+}
+
+// Выбирает сервер и занимает на нем соединение. Серверы из tried, которые
+// уже пробовались для этого запроса, пропускаются. Сервер из cookie липкой
+// сессии используется только на первой попытке, при w == nil cookie не выдается
+func (p *Proxy) pick(w http.ResponseWriter, r *http.Request, first bool, tried []loadbalancer.Backend) loadbalancer.Backend {
+	const acquireTries = 3 // Выбранный сервер мог занять конкурентный запрос
+
+	if first && p.sticky != nil {
+		if b := p.sticky.lookup(r); b != nil && p.acquire(b) {
+			return b
+		}
+	}
+	for i := 0; i != acquireTries; i++ {
+		b := p.sel.Next(r)
+		if b == nil {
+			return nil
+		}
+		if slices.Contains(tried, b) {
+			continue
+		}
+		if p.acquire(b) {
+			p.issue(w, b)
+			return b
+		}
+	}
+	return p.fallback(w, tried)
+}
+
+// Выбирает сервер в обход селектора. Hash-алгоритмы для одного ключа
+// возвращают один и тот же сервер, даже если его не пропускает автомат
+// защиты или он уже пробовался, и без этого ключ получал бы 503 при других
+// здоровых серверах, а повтор уходил бы на тот же сервер.
+// Сначала серверы ищутся там же, где выбирает селектор (своя зона, активная
+// группа приоритета), затем во всем пуле. Предпочитаются серверы с меньшим
+// приоритетом, затем с меньшей нагрузкой с учетом прогрева
+func (p *Proxy) fallback(w http.ResponseWriter, tried []loadbalancer.Backend) loadbalancer.Backend {
+	list := p.backends.Load()
+	if list == nil {
+		return nil
+	}
+	skip := slices.Clone(tried)
+	for _, group := range append(loadbalancer.Preferred(p.sel), *list) {
+		var candidates []loadbalancer.Backend
+		for _, b := range group {
+			if loadbalancer.Available(b) && !slices.Contains(skip, b) {
+				candidates = append(candidates, b)
+			}
+		}
+		slices.SortStableFunc(candidates, func(a, b loadbalancer.Backend) int {
+			return cmp.Or(cmp.Compare(a.Priority(), b.Priority()), cmp.Compare(load(a), load(b)))
+		})
+		for _, b := range candidates {
+			if p.acquire(b) {
+				p.issue(w, b)
+				return b
+			}
+			skip = append(skip, b)
+		}
+	}
+	return nil
+}
+
+// Нагрузка сервера: сервер на прогреве выглядит загруженнее пропорционально доле веса
+func load(b loadbalancer.Backend) float64 {
+	return float64(b.Conns()+1) / b.Ramp()
+}
+
+// Выдает cookie липкой сессии на сервер, при w == nil cookie не выдается
+func (p *Proxy) issue(w http.ResponseWriter, b loadbalancer.Backend) {
+	if p.sticky != nil && w != nil {
+		p.sticky.issue(w, b)
+	}
+}
+
+// Занимает соединение на сервере, если его пропускает автомат защиты
+func (p *Proxy) acquire(b loadbalancer.Backend) bool {
+	if !b.Acquire() {
+		return false
+	}
+	if p.breakers != nil && !p.breakers.allow(b) {
+		p.release(b)
+		return false
+	}
+	return true
+}
+
+// Учитывает результат попытки: код ответа или 0 при ошибке соединения
+func (p *Proxy) observe(st *attemptState, status int) {
+	st.observed = true
+	b := st.b
+	if p.outlier != nil {
+		p.outlier.observe(b, status)
+	}
+	if p.breakers != nil {
+		p.breakers.record(b, status == 0 || status >= 500)
+	}
+}
+
+// Освобождает соединение на сервере и будит очередь
+func (p *Proxy) release(b loadbalancer.Backend) {
+	b.Done()
+	if p.queue != nil {
+		p.queue.release()
+	}
 }
 
-// Основной обработчик HTTP-запросов
+// Основной обработчик HTTP-запросов. Запрос повторяется на другом сервере
+// по политике retry, пока клиенту не отправлен ни один байт ответа
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	const maxTries = 10 // Максимальное количество попыток на разные серверы
+	start := time.Now()
+	tw := &trackingWriter{ResponseWriter: w}
 
-	for i := 0; i != maxTries; i++ {
-		b := p.sel.Next()
+	// Для повтора и дублирования тело запроса нужно сохранить
+	canRetry := p.retry.allowed(r)
+	canHedge := p.hedging != nil && idempotent(r)
+	var body []byte
+	if canRetry || canHedge {
+		buffered, ok, err := bufferBody(r, p.retry.MaxBody)
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		body = buffered
+		canRetry = canRetry && ok
+		canHedge = canHedge && ok
+	}
+
+	// Повтор попытки возможен, если остались попытки и не исчерпан бюджет времени
+	retryable := func(attempt int) bool {
+		return canRetry && attempt < p.retry.Attempts &&
+			(p.retry.Budget == 0 || time.Since(start) < p.retry.Budget)
+	}
+
+	var tried []loadbalancer.Backend // Серверы, на которые уже отправлялся запрос
+	for attempt := 1; ; attempt++ {
+		b := p.pick(tw, r, attempt == 1, tried)
+		if b == nil && len(tried) != 0 {
+			// Все доступные серверы уже пробовались, повторяем на любом из них
+			b = p.pick(tw, r, false, nil)
+		}
+		if b == nil && p.queue != nil && p.queue.saturated() {
+			b = p.queue.wait(r, func() loadbalancer.Backend { return p.pick(tw, r, false, tried) })
+		}
 		if b == nil {
-			logging.L.Error("no backend alive")
+			if attempt > 1 {
+				logging.L.Error("all backends failed")
+				http.Error(w, "all backends failed", http.StatusBadGateway)
+				return
+			}
+			logging.L.Error("no backend available")
 			http.Error(w, "no backend available", http.StatusServiceUnavailable)
 			return
 		}
 
-		logging.L.Info("selected backend", "url", b.URL().String())
+		logging.L.Info("selected backend", "url", b.URL().String(), "attempt", attempt)
+		tried = append(tried, b)
 
-		// Создание прокси на конкретный сервер
-		rp := httputil.NewSingleHostReverseProxy(b.URL())
+		if attempt == 1 && canHedge {
+			// Дубль считается попыткой, после неудачи обеих запрос повторяется по политике retry
+			if retry := p.hedge(tw, r, &tried, body, retryable); !retry {
+				return
+			}
+			attempt = len(tried)
+			logging.L.Warn("retrying request", "backend", b.URL().String(), "attempt", attempt)
+			continue
+		}
 
-		// Обработка ошибок соединения
-		rp.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-			logging.L.Warn("error", "backend", b.URL().String(), "error", err)
-			b.SetAlive(false) // Помечаем сервер как мертвый
-			rw.WriteHeader(http.StatusBadGateway)
-			_, _ = rw.Write([]byte("backend unreachable"))
+		if retry := p.attempt(tw, r, b, body, retryable(attempt)); !retry {
+			return
 		}
+		logging.L.Warn("retrying request", "backend", b.URL().String(), "attempt", attempt)
+	}
+}
 
-		// Увеличиваем счетчик активных соединений
-		b.Inc()
+// Ключ контекста запроса для состояния попытки
+type attemptKey struct{}
 
-		// Уменьшаем счетчик после получения ответа
-		rp.ModifyResponse = func(resp *http.Response) error {
-			b.Done()
-			return nil
+// Состояние одной попытки, общие обработчики reverse proxy получают его из контекста запроса
+type attemptState struct {
+	b         loadbalancer.Backend
+	w         *trackingWriter
+	client    context.Context // Контекст запроса клиента, без таймаута попытки
+	start     time.Time
+	retryable bool       // Попытку можно повторить
+	retry     bool       // Ответ клиенту не записан, запрос нужно повторить
+	race      *hedgeRace // Гонка с дублем запроса, nil без дублирования
+	hedged    bool       // Попытка - дубль запроса
+	observed  bool       // Результат попытки учтен пассивной проверкой и автоматом
+}
+
+// Создает долгоживущий reverse proxy для сервера
+func (p *Proxy) newReverseProxy(b loadbalancer.Backend, t http.RoundTripper) *httputil.ReverseProxy {
+	rp := httputil.NewSingleHostReverseProxy(b.URL())
+	rp.Transport = t
+	rp.ErrorHandler = p.handleError
+	rp.ModifyResponse = p.modifyResponse
+	return rp
+}
+
+// Отправляет запрос на сервер. Возвращает true, если ответ клиенту
+// не записан и запрос нужно повторить на другом сервере
+func (p *Proxy) attempt(w *trackingWriter, r *http.Request, b loadbalancer.Backend, body []byte, retryable bool) bool {
+	st := &attemptState{b: b, w: w, client: r.Context(), start: time.Now(), retryable: retryable}
+	p.send(st, r, body)
+	return st.retry
+}
+
+// Отправляет запрос на сервер попытки st и передает ответ клиенту
+func (p *Proxy) send(st *attemptState, r *http.Request, body []byte) {
+	b := st.b
+	// Соединение освобождается только после передачи всего ответа. Попытка без
+	// результата, например отмененный дубль, возвращает место пробного запроса автомата
+	defer func() {
+		if !st.observed && p.breakers != nil {
+			p.breakers.cancel(b)
 		}
+		p.release(b)
+	}()
 
-		r.Host = b.URL().Host
-		r.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
+	ctx := context.WithValue(r.Context(), attemptKey{}, st)
+	if p.retry.PerTryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.retry.PerTryTimeout)
+		defer cancel()
+	}
+	req := r.WithContext(ctx)
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
 
-		rp.ServeHTTP(w, r)
+	req.Host = b.URL().Host
+	req.Header.Set("X-Forwarded-Host", req.Header.Get("Host"))
 
-		// Если backend ответил без ошибки
-		if b.Alive() {
-			return
+	p.upstreams.get(b, p.newReverseProxy).ServeHTTP(st.w, req)
+}
+
+// Обработка ошибок соединения
+func (p *Proxy) handleError(rw http.ResponseWriter, req *http.Request, err error) {
+	st := req.Context().Value(attemptKey{}).(*attemptState)
+	b := st.b
+	if st.client.Err() != nil {
+		// Клиент ушел, не дождавшись ответа: сервер в этом не виноват
+		logging.L.Info("client canceled request", "backend", b.URL().String())
+		return
+	}
+	// Проигравшая попытка отменена, это не ошибка сервера
+	if st.race != nil && (errors.Is(err, errHedgeLost) || st.race.won()) {
+		return
+	}
+	if !errors.Is(err, errRetryStatus) {
+		logging.L.Warn("error", "backend", b.URL().String(), "error", err)
+		if p.outlier == nil && p.breakers == nil {
+			b.SetAlive(false) // Помечаем сервер как мертвый
 		}
+		p.observe(st, 0)
+	}
+	if st.retryable && !st.w.wrote && p.retry.retryError(err) {
+		st.retry = true
+		return
+	}
+	if st.race != nil {
+		// Ответ об ошибке запишет hedge, если другая попытка тоже не ответит
+		return
 	}
+	rw.WriteHeader(http.StatusBadGateway)
+	_, _ = rw.Write([]byte("backend unreachable"))
+}
 
-	// Если ни один backend не сработал - ошибка
-	logging.L.Error("all backends failed")
-	http.Error(w, "all backends failed", http.StatusBadGateway)
+// Запоминаем задержку и код после получения заголовков ответа
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	st := resp.Request.Context().Value(attemptKey{}).(*attemptState)
+	latency := time.Since(st.start)
+	st.b.ObserveLatency(latency)
+	p.observe(st, resp.StatusCode)
+	if p.hedging != nil {
+		p.hedging.observe(latency)
+	}
+	// Ответ с кодом из списка повторов не передается клиенту и не участвует в гонке дублей
+	if st.retryable && p.retry.retryStatus(resp.StatusCode) {
+		return errRetryStatus
+	}
+	if st.race != nil {
+		if !st.race.claim(st) {
+			return errHedgeLost
+		}
+		// Cookie липкой сессии указывает на сервер, ответ которого получен
+		if st.hedged && p.sticky != nil {
+			p.sticky.issue(st.w, st.b)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+	"loadbalancer/internal/logging"
+)
+
+// queue держит запросы, для которых все серверы заняты, пока не освободится соединение
+type queue struct {
+	slots   chan struct{} // Места в очереди
+	timeout time.Duration // Сколько запрос может ждать
+	bs      atomic.Pointer[[]loadbalancer.Backend]
+
+	mu    sync.Mutex
+	ready chan struct{} // Закрывается, когда какой-то сервер освободил соединение
+}
+
+// Создает очередь заданной длины для серверов bs
+func newQueue(bs []loadbalancer.Backend, size int, timeout time.Duration) *queue {
+	q := &queue{
+		slots:   make(chan struct{}, size),
+		timeout: timeout,
+		ready:   make(chan struct{}),
+	}
+	q.update(bs)
+	return q
+}
+
+// Обновляет список серверов после изменения пула
+func (q *queue) update(bs []loadbalancer.Backend) {
+	q.bs.Store(&bs)
+}
+
+// Сообщает, есть ли живой сервер, который уперся в лимит соединений.
+// Ждать в очереди имеет смысл только освобождения такого сервера,
+// а если все серверы мертвы, выводятся из работы или исключены автоматом
+// защиты, запрос сразу получает 503
+func (q *queue) saturated() bool {
+	for _, b := range *q.bs.Load() {
+		if loadbalancer.Serving(b) && b.MaxConns() > 0 && b.Conns() >= b.MaxConns() {
+			return true
+		}
+	}
+	return false
+}
+
+// Ставит запрос в очередь и пробует выбрать сервер после каждого освобождения
+// соединения. Возвращает nil, если очередь заполнена или время ожидания вышло
+func (q *queue) wait(r *http.Request, pick func() loadbalancer.Backend) loadbalancer.Backend {
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		logging.L.Warn("request queue full", "depth", len(q.slots))
+		return nil
+	}
+	defer func() { <-q.slots }()
+	logging.L.Info("request queued", "depth", len(q.slots))
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	for {
+		q.mu.Lock()
+		ready := q.ready
+		q.mu.Unlock()
+
+		if b := pick(); b != nil {
+			return b
+		}
+		select {
+		case <-ready:
+		case <-timer.C:
+			logging.L.Warn("request queue timeout", "depth", len(q.slots))
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Будит ожидающие запросы после освобождения соединения
+func (q *queue) release() {
+	q.mu.Lock()
+	close(q.ready)
+	q.ready = make(chan struct{})
+	q.mu.Unlock()
+}
+
+// Возвращает количество запросов в очереди
+func (q *queue) depth() int { return len(q.slots) }
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Ответ сервера с кодом из списка повторов, запрос нужно отправить на другой сервер
+var errRetryStatus = errors.New("retryable status")
+
+// Политика повторных попыток запроса
+type RetryPolicy struct {
+	Attempts       int           // Максимум попыток, включая первую
+	OnConnectError bool          // Повторять при ошибке соединения
+	OnTimeout      bool          // Повторять при истечении PerTryTimeout
+	OnStatus       []int         // Повторять при этих кодах ответа
+	NonIdempotent  bool          // Повторять и неидемпотентные методы
+	MaxBody        int64         // Максимальный размер тела, которое буферизуется для повтора
+	PerTryTimeout  time.Duration // Таймаут одной попытки, 0 - без таймаута
+	Budget         time.Duration // Время, после которого новые попытки не начинаются, 0 - без ограничения
+}
+
+// Политика по умолчанию: до трех попыток идемпотентных запросов при ошибке соединения
+var defaultRetry = RetryPolicy{Attempts: 3, OnConnectError: true, MaxBody: 64 << 10}
+
+// Задает политику повторных попыток
+func WithRetry(policy RetryPolicy) Option {
+	return func(p *Proxy) {
+		if policy.Attempts < 1 {
+			policy.Attempts = 1
+		}
+		p.retry = policy
+	}
+}
+
+// Сообщает, можно ли повторять запрос по его методу
+func (rp RetryPolicy) allowed(r *http.Request) bool {
+	if rp.Attempts < 2 {
+		return false
+	}
+	return rp.NonIdempotent || idempotent(r)
+}
+
+// Сообщает, идемпотентен ли запрос по его методу. Заголовок Idempotency-Key
+// не учитывается: сервер может его не поддерживать, и повтор POST выполнил бы
+// операцию дважды. Неидемпотентные запросы повторяются только с NonIdempotent
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Сообщает, нужно ли повторять запрос после ошибки
+func (rp RetryPolicy) retryError(err error) bool {
+	if errors.Is(err, errRetryStatus) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return rp.OnTimeout
+	}
+	var op *net.OpError
+	if errors.As(err, &op) && op.Op == "dial" {
+		return rp.OnConnectError
+	}
+	return false
+}
+
+// Сообщает, нужно ли повторять запрос после ответа с кодом code
+func (rp RetryPolicy) retryStatus(code int) bool {
+	return slices.Contains(rp.OnStatus, code)
+}
+
+// Читает тело запроса в память, чтобы отправить его повторно. Если тело
+// больше limit, повтор невозможен: тело восстанавливается для одной попытки
+func bufferBody(r *http.Request, limit int64) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(buf)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return nil, false, nil
+	}
+	_ = r.Body.Close()
+	return buf, true, nil
+}
+
+// trackingWriter запоминает, начали ли передаваться клиенту байты ответа.
+// После этого повторять запрос нельзя
+type trackingWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (t *trackingWriter) WriteHeader(code int) {
+	t.wrote = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.wrote = true
+	return t.ResponseWriter.Write(p)
+}
+
+// Unwrap дает ResponseController доступ к Flush и Hijack исходного writer
+func (t *trackingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// Имя cookie липкой сессии по умолчанию
+const defaultStickyCookie = "lb_backend"
+
+// sticky привязывает клиента к серверу через cookie, выданную балансировщиком.
+// Значение cookie - HMAC имени сервера: его нельзя подделать и по нему не видно адрес
+type sticky struct {
+	cookie string
+	ttl    time.Duration
+	secret []byte
+
+	mu     sync.RWMutex
+	tokens map[string]loadbalancer.Backend // Значение cookie - сервер
+}
+
+// Создает липкие сессии для списка серверов
+func newSticky(bs []loadbalancer.Backend, cookie string, secret []byte, ttl time.Duration) *sticky {
+	if cookie == "" {
+		cookie = defaultStickyCookie
+	}
+	s := &sticky{cookie: cookie, ttl: ttl, secret: secret}
+	s.update(bs)
+	return s
+}
+
+// Пересчитывает идентификаторы после изменения пула
+func (s *sticky) update(bs []loadbalancer.Backend) {
+	tokens := make(map[string]loadbalancer.Backend, len(bs))
+	for _, b := range bs {
+		tokens[s.token(b)] = b
+	}
+	s.mu.Lock()
+	s.tokens = tokens
+	s.mu.Unlock()
+}
+
+// Возвращает подписанный идентификатор сервера
+func (s *sticky) token(b loadbalancer.Backend) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(b.Name()))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Возвращает сервер из cookie запроса, если он есть в пуле и может принять запрос
+func (s *sticky) lookup(r *http.Request) loadbalancer.Backend {
+	c, err := r.Cookie(s.cookie)
+	if err != nil {
+		return nil
+	}
+	s.mu.RLock()
+	b, ok := s.tokens[c.Value]
+	s.mu.RUnlock()
+	if !ok || !loadbalancer.Available(b) {
+		return nil
+	}
+	return b
+}
+
+// Выдает cookie на выбранный сервер, заменяя выданную ранее в этом ответе
+func (s *sticky) issue(w http.ResponseWriter, b loadbalancer.Backend) {
+	c := &http.Cookie{
+		Name:     s.cookie,
+		Value:    s.token(b),
+		Path:     "/",
+		HttpOnly: true,
+	}
+	if s.ttl > 0 {
+		c.MaxAge = int(s.ttl.Seconds())
+	}
+
+	h := w.Header()
+	prev := h.Values("Set-Cookie")
+	h.Del("Set-Cookie")
+	for _, v := range prev {
+		if pc, err := http.ParseSetCookie(v); err == nil && pc.Name == s.cookie {
+			continue
+		}
+		h.Add("Set-Cookie", v)
+	}
+	http.SetCookie(w, c)
+}
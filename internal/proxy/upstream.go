@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// Параметры соединений с серверами
+type TransportConfig struct {
+	MaxIdleConns          int           // Максимум простаивающих соединений на сервер
+	MaxConnsPerHost       int           // Максимум соединений на сервер, 0 - без ограничения
+	IdleConnTimeout       time.Duration // Сколько простаивающее соединение остается открытым
+	DialTimeout           time.Duration // Таймаут установки соединения
+	KeepAlive             time.Duration // Период TCP keep-alive, отрицательный - выключен
+	TLSHandshakeTimeout   time.Duration // Таймаут TLS-рукопожатия
+	ResponseHeaderTimeout time.Duration // Таймаут ожидания заголовков ответа, 0 - без таймаута
+	DisableKeepAlives     bool          // Новое соединение на каждый запрос
+}
+
+// Параметры по умолчанию, как у http.DefaultTransport, но с большим пулом
+// простаивающих соединений: у DefaultTransport их всего 2 на сервер
+var defaultTransport = TransportConfig{
+	MaxIdleConns:        256,
+	IdleConnTimeout:     90 * time.Second,
+	DialTimeout:         30 * time.Second,
+	KeepAlive:           30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// Задает параметры соединений с серверами, нулевые поля берутся по умолчанию
+func WithTransport(cfg TransportConfig) Option {
+	return func(p *Proxy) {
+		def := defaultTransport
+		if cfg.MaxIdleConns <= 0 {
+			cfg.MaxIdleConns = def.MaxIdleConns
+		}
+		if cfg.IdleConnTimeout <= 0 {
+			cfg.IdleConnTimeout = def.IdleConnTimeout
+		}
+		if cfg.DialTimeout <= 0 {
+			cfg.DialTimeout = def.DialTimeout
+		}
+		if cfg.KeepAlive == 0 {
+			cfg.KeepAlive = def.KeepAlive
+		}
+		if cfg.TLSHandshakeTimeout <= 0 {
+			cfg.TLSHandshakeTimeout = def.TLSHandshakeTimeout
+		}
+		p.upstreams.cfg = cfg
+	}
+}
+
+// Создает транспорт для одного сервера
+func newTransport(cfg TransportConfig) *http.Transport {
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: cfg.KeepAlive}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConns,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+	}
+}
+
+// upstreams хранит долгоживущие reverse proxy серверов пула, каждый со своим
+// пулом соединений. Создаются при первом запросе на сервер
+type upstreams struct {
+	cfg TransportConfig
+
+	mu sync.RWMutex
+	m  map[loadbalancer.Backend]*httputil.ReverseProxy
+}
+
+// Возвращает reverse proxy сервера, создавая его через build при первом обращении
+func (u *upstreams) get(b loadbalancer.Backend, build func(b loadbalancer.Backend, t http.RoundTripper) *httputil.ReverseProxy) *httputil.ReverseProxy {
+	u.mu.RLock()
+	rp, ok := u.m[b]
+	u.mu.RUnlock()
+	if ok {
+		return rp
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if rp, ok := u.m[b]; ok {
+		return rp
+	}
+	if u.m == nil {
+		u.m = map[loadbalancer.Backend]*httputil.ReverseProxy{}
+	}
+	rp = build(b, newTransport(u.cfg))
+	u.m[b] = rp
+	return rp
+}
+
+// Удаляет reverse proxy серверов, которых больше нет в пуле, и закрывает
+// их простаивающие соединения. Запросы в работе завершаются
+func (u *upstreams) update(bs []loadbalancer.Backend) {
+	keep := make(map[loadbalancer.Backend]bool, len(bs))
+	for _, b := range bs {
+		keep[b] = true
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for b, rp := range u.m {
+		if !keep[b] {
+			if t, ok := rp.Transport.(*http.Transport); ok {
+				t.CloseIdleConnections()
+			}
+			delete(u.m, b)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"loadbalancer/internal/logging"
+)
+
+// Route описывает правило маршрутизации. Пустые условия не проверяются,
+// запрос должен удовлетворять всем заданным
+type Route struct {
+	Name       string            // Имя правила для логов
+	Host       string            // Имя хоста, "*.example.com" - любой поддомен
+	PathPrefix string            // Префикс пути
+	PathRegex  *regexp.Regexp    // Регулярное выражение для пути
+	Methods    []string          // Допустимые методы
+	Headers    map[string]string // Заголовки: пустое значение - заголовок просто присутствует
+	Handler    http.Handler      // Обработчик запросов, подошедших под правило
+}
+
+// Сообщает, подходит ли запрос под правило
+func (rt *Route) match(r *http.Request) bool {
+	if rt.Host != "" && !matchHost(rt.Host, r.Host) {
+		return false
+	}
+	if rt.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, rt.PathPrefix) {
+		return false
+	}
+	if rt.PathRegex != nil && !rt.PathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(rt.Methods) != 0 && !slices.Contains(rt.Methods, r.Method) {
+		return false
+	}
+	for k, v := range rt.Headers {
+		got, ok := r.Header[http.CanonicalHeaderKey(k)]
+		if !ok || (v != "" && !slices.Contains(got, v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Сравнивает хост запроса с шаблоном без учета порта и регистра
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+// Router передает запрос обработчику первого подходящего правила
+type Router struct {
+	routes   []Route
+	fallback http.Handler // Обработчик, если ни одно правило не подошло, nil - 404
+}
+
+// Создает маршрутизатор. Правила проверяются в порядке объявления
+func New(routes []Route, fallback http.Handler) *Router {
+	return &Router{routes: routes, fallback: fallback}
+}
+
+func (rr *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for i := range rr.routes {
+		if rt := &rr.routes[i]; rt.match(r) {
+			rt.Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	if rr.fallback != nil {
+		rr.fallback.ServeHTTP(w, r)
+		return
+	}
+	logging.L.Warn("no route matched", "host", r.Host, "method", r.Method, "path", r.URL.Path)
+	http.Error(w, "no route", http.StatusNotFound)
+}
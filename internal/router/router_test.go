@@ -0,0 +1,59 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// Возвращает обработчик, который отвечает своим именем
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(name))
+	})
+}
+
+func TestRouter(t *testing.T) {
+	rr := New([]Route{
+		{Name: "admin", Host: "admin.example.com", Handler: named("admin")},
+		{Name: "users-v2", PathPrefix: "/users", Headers: map[string]string{"X-Version": "2"}, Handler: named("users-v2")},
+		{Name: "users", PathPrefix: "/users", Methods: []string{http.MethodGet}, Handler: named("users")},
+		{Name: "static", Host: "*.cdn.example.com", PathRegex: regexp.MustCompile(`\.(css|js)$`), Handler: named("static")},
+	}, named("default"))
+
+	tests := []struct {
+		method, host, path string
+		headers            map[string]string
+		want               string
+	}{
+		{"GET", "admin.example.com:8080", "/", nil, "admin"},
+		{"GET", "ADMIN.example.com", "/users", nil, "admin"},
+		{"POST", "lb", "/users/1", map[string]string{"X-Version": "2"}, "users-v2"},
+		{"GET", "lb", "/users/1", nil, "users"},
+		{"POST", "lb", "/users/1", nil, "default"},
+		{"GET", "eu.cdn.example.com", "/app.js", nil, "static"},
+		{"GET", "cdn.example.com", "/app.js", nil, "default"},
+		{"GET", "eu.cdn.example.com", "/index.html", nil, "default"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, "http://"+tc.host+tc.path, nil)
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		rr.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tc.want {
+			t.Errorf("%s %s%s: expected %q, got %q", tc.method, tc.host, tc.path, tc.want, got)
+		}
+	}
+}
+
+func TestRouter_NoMatch(t *testing.T) {
+	rr := New([]Route{{PathPrefix: "/api", Handler: named("api")}}, nil)
+	rec := httptest.NewRecorder()
+	rr.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
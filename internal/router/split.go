@@ -0,0 +1,130 @@
+package router
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+
+	"loadbalancer/internal/loadbalancer"
+)
+
+// Вариант разделения трафика, например stable или canary
+type Variant struct {
+	Name    string       // Имя варианта, обычно имя пула
+	Weight  int          // Доля трафика относительно суммы весов
+	Handler http.Handler // Обработчик запросов варианта
+}
+
+// Вес варианта для API
+type VariantWeight struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// Split делит запросы между вариантами пропорционально весам. С ключом
+// клиента выбор детерминирован: клиент остается на своем варианте, пока
+// не изменятся веса, а при росте доли варианта к нему только добавляются клиенты
+type Split struct {
+	key loadbalancer.KeyFunc // Ключ клиента, nil - случайный выбор
+
+	mu       sync.RWMutex
+	variants []Variant
+}
+
+// Создает разделение трафика. key - ключ клиента для привязки к варианту, nil без привязки
+func NewSplit(variants []Variant, key loadbalancer.KeyFunc) (*Split, error) {
+	if err := checkWeights(variants); err != nil {
+		return nil, err
+	}
+	return &Split{key: key, variants: variants}, nil
+}
+
+// Проверяет, что веса неотрицательны и хотя бы один больше нуля
+func checkWeights(variants []Variant) error {
+	total := 0
+	for _, v := range variants {
+		if v.Weight < 0 {
+			return fmt.Errorf("variant %q: negative weight %d", v.Name, v.Weight)
+		}
+		total += v.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("split needs a variant with positive weight")
+	}
+	return nil
+}
+
+// Точек на шкале, по которой распределяются клиенты
+const splitBuckets = 10000
+
+// Возвращает вариант для запроса
+func (s *Split) choose(r *http.Request) *Variant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	total := 0
+	for _, v := range s.variants {
+		total += v.Weight
+	}
+
+	// Точка клиента на шкале [0, 1) не зависит от весов
+	var point float64
+	if s.key != nil {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(s.key(r)))
+		point = float64(h.Sum64()%splitBuckets) / splitBuckets
+	} else {
+		point = rand.Float64()
+	}
+
+	at := point * float64(total)
+	acc := 0
+	for i := range s.variants {
+		acc += s.variants[i].Weight
+		if at < float64(acc) {
+			return &s.variants[i]
+		}
+	}
+	return &s.variants[len(s.variants)-1]
+}
+
+func (s *Split) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.choose(r).Handler.ServeHTTP(w, r)
+}
+
+// Возвращает текущие веса вариантов
+func (s *Split) Weights() []VariantWeight {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]VariantWeight, 0, len(s.variants))
+	for _, v := range s.variants {
+		out = append(out, VariantWeight{Name: v.Name, Weight: v.Weight})
+	}
+	return out
+}
+
+// Меняет веса вариантов по имени, не указанные варианты сохраняют свой вес
+func (s *Split) SetWeights(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := make([]Variant, len(s.variants))
+	copy(next, s.variants)
+	for name, w := range weights {
+		found := false
+		for i := range next {
+			if next[i].Name == name {
+				next[i].Weight = w
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown variant %q", name)
+		}
+	}
+	if err := checkWeights(next); err != nil {
+		return err
+	}
+	s.variants = next
+	return nil
+}
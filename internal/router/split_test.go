@@ -0,0 +1,111 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Отправляет запрос клиента user и возвращает имя варианта
+func serve(h http.Handler, user string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User", user)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func userKey(r *http.Request) string { return r.Header.Get("X-User") }
+
+func TestSplit_Weights(t *testing.T) {
+	s, err := NewSplit([]Variant{
+		{Name: "stable", Weight: 90, Handler: named("stable")},
+		{Name: "canary", Weight: 10, Handler: named("canary")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10000
+	canary := 0
+	for i := 0; i < n; i++ {
+		if serve(s, "") == "canary" {
+			canary++
+		}
+	}
+	// 10% с запасом на случайность
+	if canary < n*7/100 || canary > n*13/100 {
+		t.Errorf("expected about 10%% canary, got %d of %d", canary, n)
+	}
+}
+
+func TestSplit_Sticky(t *testing.T) {
+	s, err := NewSplit([]Variant{
+		{Name: "stable", Weight: 95, Handler: named("stable")},
+		{Name: "canary", Weight: 5, Handler: named("canary")},
+	}, userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		before[user] = serve(s, user)
+		if again := serve(s, user); again != before[user] {
+			t.Fatalf("%s moved from %s to %s", user, before[user], again)
+		}
+	}
+
+	// При росте доли canary его клиенты остаются на нем
+	if err := s.SetWeights(map[string]int{"stable": 50, "canary": 50}); err != nil {
+		t.Fatal(err)
+	}
+	moved := 0
+	for user, was := range before {
+		now := serve(s, user)
+		if was == "canary" && now != "canary" {
+			t.Errorf("%s left canary after increase", user)
+		}
+		if was != now {
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Error("expected some users to move to canary")
+	}
+}
+
+func TestSplit_SetWeights(t *testing.T) {
+	s, err := NewSplit([]Variant{
+		{Name: "stable", Weight: 1, Handler: named("stable")},
+		{Name: "canary", Weight: 0, Handler: named("canary")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serve(s, ""); got != "stable" {
+		t.Errorf("expected stable with zero canary weight, got %s", got)
+	}
+
+	if err := s.SetWeights(map[string]int{"missing": 1}); err == nil {
+		t.Error("expected error for unknown variant")
+	}
+	if err := s.SetWeights(map[string]int{"stable": 0}); err == nil {
+		t.Error("expected error for zero total weight")
+	}
+	if err := s.SetWeights(map[string]int{"stable": 0, "canary": 100}); err != nil {
+		t.Fatal(err)
+	}
+	if got := serve(s, ""); got != "canary" {
+		t.Errorf("expected canary after switch, got %s", got)
+	}
+	if w := s.Weights(); w[0].Weight != 0 || w[1].Weight != 100 {
+		t.Errorf("unexpected weights %v", w)
+	}
+
+	if _, err := NewSplit([]Variant{{Name: "a", Weight: -1, Handler: named("a")}}, nil); err == nil {
+		t.Error("expected error for negative weight")
+	}
+}
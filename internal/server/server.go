@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,33 +14,51 @@ import (
 )
 
 type HTTPServer struct {
-	srv *http.Server
+	srv   *http.Server
+	admin *http.Server // API управления, nil если не задано
 }
 
 // Создает HTTP-сервер с тайм-аутами из конфига и переданным handler.
 func New(cfg *config.Config, handler http.Handler) *HTTPServer {
-	srv := &http.Server{
-		Addr:         cfg.ListenAddr,
+	return &HTTPServer{srv: newServer(cfg.ListenAddr, handler)}
+}
+
+// Задает обработчик API управления, он слушает отдельный адрес
+func (s *HTTPServer) WithAdmin(addr string, handler http.Handler) *HTTPServer {
+	s.admin = newServer(addr, handler)
+	return s
+}
+
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
 		Handler:      handler,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  30 * time.Second,
 	}
-	return &HTTPServer{srv: srv}
 }
 
-// Запускает сервер в горутине
-func (s *HTTPServer) Start() error {
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-
+// Запускает сервер srv в горутине
+func listen(srv *http.Server) {
 	go func() {
-		logging.L.Info("server start", "addr", s.srv.Addr)
-		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logging.L.Info("server start", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logging.L.Error("listen fail", "error", err)
 			os.Exit(1)
 		}
 	}()
+}
+
+// Запускает сервер в горутине
+func (s *HTTPServer) Start() error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	listen(s.srv)
+	if s.admin != nil {
+		listen(s.admin)
+	}
 
 	<-stop // Блокировка до сигнала завершения
 	logging.L.Info("server shutdown")
@@ -47,6 +66,10 @@ func (s *HTTPServer) Start() error {
 	// Контекст с таймаутом
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	if s.admin != nil {
+		// Долгие запросы API (события, ожидание draining) не держат завершение
+		_ = s.admin.Close()
+	}
 	if err := s.srv.Shutdown(ctx); err != nil {
 		logging.L.Error("shutdown error", "error", err)
 		return err
@@ -63,6 +86,33 @@ func chain(h http.Handler, m ...func(http.Handler) http.Handler) http.Handler {
 	return h
 }
 
+// Пропускает только запросы с заголовком Authorization: Bearer <token>,
+// при пустом token проверки нет
+func Auth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+		want := []byte("Bearer " + token)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Объединяет API управления, проверку токена и логирование в один обработчик
+func BuildAdminHandler(admin http.Handler, token string) http.Handler {
+	return chain(admin,
+		requestCtx,
+		Auth(token),
+	)
+}
+
 // Объединяет proxy, ratelimiter и логирование в один обработчик
 func BuildHandler(proxy http.Handler, rlMw func(http.Handler) http.Handler) http.Handler {
 	return chain(proxy,
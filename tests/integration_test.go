@@ -1,16 +1,24 @@
 package tests
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"loadbalancer/internal/api"
+	"loadbalancer/internal/healthcheck"
 	"loadbalancer/internal/loadbalancer"
 	"loadbalancer/internal/proxy"
 	"loadbalancer/internal/ratelimiter"
+	"loadbalancer/internal/router"
 	"loadbalancer/internal/server"
 )
 
@@ -36,7 +44,7 @@ func TestLoadBalancerIntegration(t *testing.T) {
 	bs := []loadbalancer.Backend{b1, b2}
 
 	sel := loadbalancer.NewRoundRobin(bs)
-	px := proxy.New(sel)
+	px := proxy.New(sel, bs)
 	rl := ratelimiter.NewStore(1000, 1000, nil)
 	handler := server.BuildHandler(px, rl.Middleware)
 	ts := httptest.NewServer(handler)
@@ -73,6 +81,1055 @@ func TestLoadBalancerIntegration(t *testing.T) {
 	}
 }
 
+func TestStickySessions(t *testing.T) {
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		}))
+	}
+	backend1 := newServer("backend1")
+	defer backend1.Close()
+	backend2 := newServer("backend2")
+	defer backend2.Close()
+
+	b1, _ := loadbalancer.NewBackend(backend1.URL)
+	b2, _ := loadbalancer.NewBackend(backend2.URL)
+	bs := []loadbalancer.Backend{b1, b2}
+	px := proxy.New(loadbalancer.NewRoundRobin(bs), bs, proxy.WithSticky(bs, "lb", []byte("secret"), time.Hour))
+	ts := httptest.NewServer(px)
+	defer ts.Close()
+
+	get := func(c *http.Cookie) (string, *http.Cookie) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		for _, rc := range resp.Cookies() {
+			if rc.Name == "lb" {
+				return string(body), rc
+			}
+		}
+		return string(body), nil
+	}
+
+	first, cookie := get(nil)
+	if cookie == nil {
+		t.Fatal("expected sticky cookie on first request")
+	}
+	for i := 0; i != 5; i++ {
+		got, c := get(cookie)
+		if got != first {
+			t.Fatalf("sticky request went to %s, want %s", got, first)
+		}
+		if c != nil {
+			t.Error("cookie re-issued for alive backend")
+		}
+	}
+
+	// Подделанная cookie игнорируется
+	if _, c := get(&http.Cookie{Name: "lb", Value: "forged"}); c == nil {
+		t.Error("expected new cookie for forged value")
+	}
+
+	// Сервер из cookie упал - выбирается другой и cookie перевыдается
+	if first == "backend1" {
+		b1.SetAlive(false)
+	} else {
+		b2.SetAlive(false)
+	}
+	got, c := get(cookie)
+	if got == first || c == nil || c.Value == cookie.Value {
+		t.Errorf("expected fallback with new cookie, got %s", got)
+	}
+}
+
+func TestMaxConnsQueue(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	b, _ := loadbalancer.NewBackend(backend.URL)
+	b.SetMaxConns(1)
+	bs := []loadbalancer.Backend{b}
+	px := proxy.New(loadbalancer.NewRoundRobin(bs), bs, proxy.WithQueue(bs, 1, 2*time.Second))
+	ts := httptest.NewServer(px)
+	defer ts.Close()
+
+	codes := make(chan int, 3)
+	get := func() {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}
+
+	// Первый запрос занимает единственное соединение, второй встает в очередь
+	go get()
+	for b.Conns() != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	go get()
+	for px.QueueDepth() != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Длина очереди видна в API управления
+	mux := http.NewServeMux()
+	api.NewBackends(nil, loadbalancer.Spec{}, nil, px).Register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/queue", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"depth":1}` {
+		t.Errorf("expected queue depth in API, got %s", body)
+	}
+
+	// Третьему нет места в очереди
+	get()
+	if code := <-codes; code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with full queue, got %d", code)
+	}
+
+	close(release)
+	for i := 0; i != 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("expected 200, got %d", code)
+		}
+	}
+
+	// Мертвый сервер не освободится, запрос не ждет в очереди
+	b.SetAlive(false)
+	start := time.Now()
+	get()
+	if code := <-codes; code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with dead backend, got %d", code)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("request waited in queue for %v with dead backend", d)
+	}
+}
+
+func TestOutlierDetection(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "good")
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	bGood, _ := loadbalancer.NewBackend(good.URL)
+	bBad, _ := loadbalancer.NewBackend(bad.URL)
+	bs := []loadbalancer.Backend{bGood, bBad}
+	px := proxy.New(loadbalancer.NewRoundRobin(bs), bs, proxy.WithOutlierDetection(bs, proxy.OutlierConfig{
+		Consecutive5xx:    3,
+		BaseEjection:      time.Hour,
+		MaxEjectedPercent: 50,
+	}))
+	ts := httptest.NewServer(px)
+	defer ts.Close()
+
+	errors := 0
+	for i := 0; i != 20; i++ {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			errors++
+		}
+	}
+
+	// После трех ответов 5xx подряд сервер исключается
+	if errors != 3 {
+		t.Errorf("expected 3 errors before ejection, got %d", errors)
+	}
+	if bBad.Alive() || !px.Ejected(bBad) {
+		t.Error("expected bad backend ejected")
+	}
+
+	// Исправный сервер не исключается
+	if px.Ejected(bGood) {
+		t.Error("good backend must not be ejected")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var broken atomic.Bool
+	broken.Store(true)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	b, _ := loadbalancer.NewBackend(backend.URL)
+	bs := []loadbalancer.Backend{b}
+	px := proxy.New(loadbalancer.NewRoundRobin(bs), bs, proxy.WithCircuitBreaker(proxy.BreakerConfig{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         100 * time.Millisecond,
+	}))
+	ts := httptest.NewServer(px)
+	defer ts.Close()
+
+	get := func() int {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	state := func() string {
+		st, _ := px.Breaker(b)
+		return st.State
+	}
+
+	// Две ошибки подряд размыкают автомат, сервер исключается,
+	// но живость по health check не меняется
+	get()
+	get()
+	if state() != proxy.BreakerOpen || !b.Tripped() || !b.Alive() {
+		t.Fatalf("expected open breaker, got %s", state())
+	}
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with open breaker, got %d", code)
+	}
+
+	// По таймауту автомат пропускает пробный запрос и замыкается после успеха
+	broken.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	if state() != proxy.BreakerHalfOpen || b.Tripped() {
+		t.Fatalf("expected half-open breaker, got %s", state())
+	}
+	if code := get(); code != http.StatusOK {
+		t.Errorf("expected 200 on trial request, got %d", code)
+	}
+	if state() != proxy.BreakerClosed {
+		t.Errorf("expected closed breaker, got %s", state())
+	}
+}
+
+func TestBreakerKeepsHealthState(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	b, _ := loadbalancer.NewBackend(backend.URL)
+	bs := []loadbalancer.Backend{b}
+	px := proxy.New(loadbalancer.NewRoundRobin(bs), bs, proxy.WithCircuitBreaker(proxy.BreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         50 * time.Millisecond,
+	}))
+	ts := httptest.NewServer(px)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Health check признал сервер мертвым, пока автомат разомкнут
+	b.SetAlive(false)
+	time.Sleep(100 * time.Millisecond)
+	if st, _ := px.Breaker(b); st.State != proxy.BreakerHalfOpen {
+		t.Fatalf("expected half-open breaker, got %s", st.State)
+	}
+	if b.Alive() || loadbalancer.Available(b) {
+		t.Error("half-open breaker must not revive backend marked down by health check")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var failedHits atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failedHits.Add(1)
+		_, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer echo.Close()
+
+	newProxy := func(policy proxy.RetryPolicy) *httptest.Server {
+		bFail, _ := loadbalancer.NewBackend(failing.URL)
+		bEcho, _ := loadbalancer.NewBackend(echo.URL)
+		bs := []loadbalancer.Backend{bFail, bEcho}
+		return httptest.NewServer(proxy.New(loadbalancer.NewRoundRobin(bs), bs, proxy.WithRetry(policy)))
+	}
+	post := func(ts *httptest.Server, body string) (int, string) {
+		resp, err := http.Post(ts.URL, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	// POST не повторяется без явного разрешения
+	ts := newProxy(proxy.RetryPolicy{Attempts: 2, OnStatus: []int{503}, MaxBody: 1024})
+	if code, _ := post(ts, "payload"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without retry, got %d", code)
+	}
+	ts.Close()
+
+	// Заголовок Idempotency-Key не разрешает повтор
+	ts = newProxy(proxy.RetryPolicy{Attempts: 2, OnStatus: []int{503}, MaxBody: 1024})
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("payload"))
+	req.Header.Set("Idempotency-Key", "42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for POST with Idempotency-Key, got %d", resp.StatusCode)
+	}
+	ts.Close()
+
+	// С разрешением тело отправляется повторно на другой сервер
+	ts = newProxy(proxy.RetryPolicy{Attempts: 2, OnStatus: []int{503}, NonIdempotent: true, MaxBody: 1024})
+	if code, body := post(ts, "payload"); code != http.StatusOK || body != "payload" {
+		t.Errorf("expected retried payload, got %d %q", code, body)
+	}
+
+	// Тело больше лимита не буферизуется, повтора нет
+	big := strings.Repeat("x", 2048)
+	failedHits.Store(0)
+	if code, _ := post(ts, big); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for body over limit, got %d", code)
+	}
+	if failedHits.Load() != 1 {
+		t.Errorf("expected single attempt, got %d", failedHits.Load())
+	}
+	ts.Close()
+}
+
+func TestRetryConnectError(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer alive.Close()
+
+	bDead, _ := loadbalancer.NewBackend(dead.URL)
+	bAlive, _ := loadbalancer.NewBackend(alive.URL)
+	bs := []loadbalancer.Backend{bDead, bAlive}
+	ts := httptest.NewServer(proxy.New(loadbalancer.NewRoundRobin(bs), bs))
+	defer ts.Close()
+
+	// Политика по умолчанию повторяет GET при ошибке соединения
+	for i := 0; i != 4; i++ {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected 200 after retry, got %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestHedgedRequests(t *testing.T) {
+	var slowCanceled atomic.Bool
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+			fmt.Fprint(w, "slow")
+		case <-r.Context().Done():
+			slowCanceled.Store(true)
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "fast")
+	}))
+	defer fast.Close()
+
+	bSlow, _ := loadbalancer.NewBackend(slow.URL)
+	bFast, _ := loadbalancer.NewBackend(fast.URL)
+	bs := []loadbalancer.Backend{bSlow, bFast}
+	px := proxy.New(loadbalancer.NewRoundRobin(bs), bs, proxy.WithHedging(proxy.HedgePolicy{
+		Delay:   50 * time.Millisecond,
+		MaxRate: 1,
+	}))
+	ts := httptest.NewServer(px)
+	defer ts.Close()
+
+	// Медленный сервер не ответил за задержку, ответ приходит от дубля
+	start := time.Now()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "fast" {
+		t.Errorf("expected response from hedged request, got %q", body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged request took %v", elapsed)
+	}
+
+	// Проигравший запрос отменяется
+	deadline := time.Now().Add(time.Second)
+	for !slowCanceled.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !slowCanceled.Load() {
+		t.Error("expected slow request canceled")
+	}
+	if bSlow.Conns() != 0 || bFast.Conns() != 0 {
+		t.Errorf("expected connections released, got %d and %d", bSlow.Conns(), bFast.Conns())
+	}
+}
+
+func TestRouting(t *testing.T) {
+	named := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		}))
+	}
+	apiSrv, webSrv := named("api"), named("web")
+	defer apiSrv.Close()
+	defer webSrv.Close()
+
+	upstream := func(ts *httptest.Server) *proxy.Proxy {
+		b, _ := loadbalancer.NewBackend(ts.URL)
+		bs := []loadbalancer.Backend{b}
+		return proxy.New(loadbalancer.NewRoundRobin(bs), bs)
+	}
+	rtr := router.New([]router.Route{
+		{Name: "api", PathPrefix: "/api/", Handler: upstream(apiSrv)},
+		{Name: "admin", Host: "admin.example.com", Handler: upstream(apiSrv)},
+	}, upstream(webSrv))
+	ts := httptest.NewServer(rtr)
+	defer ts.Close()
+
+	get := func(host, path string) string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	cases := []struct{ host, path, want string }{
+		{"example.com", "/api/users", "api"},
+		{"admin.example.com", "/", "api"},
+		{"example.com", "/index.html", "web"},
+	}
+	for _, c := range cases {
+		if got := get(c.host, c.path); got != c.want {
+			t.Errorf("%s%s: expected %q, got %q", c.host, c.path, c.want, got)
+		}
+	}
+}
+
+func TestTrafficSplit(t *testing.T) {
+	named := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		}))
+	}
+	stableSrv, canarySrv := named("stable"), named("canary")
+	defer stableSrv.Close()
+	defer canarySrv.Close()
+
+	upstream := func(ts *httptest.Server) *proxy.Proxy {
+		b, _ := loadbalancer.NewBackend(ts.URL)
+		bs := []loadbalancer.Backend{b}
+		return proxy.New(loadbalancer.NewRoundRobin(bs), bs)
+	}
+	key, _ := loadbalancer.NewKeyFunc("header", "X-Api-Key")
+	split, err := router.NewSplit([]router.Variant{
+		{Name: "stable", Weight: 95, Handler: upstream(stableSrv)},
+		{Name: "canary", Weight: 5, Handler: upstream(canarySrv)},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(router.New([]router.Route{{Name: "web", Handler: split}}, nil))
+	defer ts.Close()
+
+	mux := http.NewServeMux()
+	api.NewRoutes(map[string]*router.Split{"web": split}).Register(mux)
+	admin := httptest.NewServer(mux)
+	defer admin.Close()
+
+	get := func(client string) string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-Api-Key", client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// Клиент остается на своем варианте
+	variants := map[string]string{}
+	canary := 0
+	for i := 0; i < 200; i++ {
+		client := fmt.Sprintf("client-%d", i)
+		variants[client] = get(client)
+		if get(client) != variants[client] {
+			t.Fatalf("%s switched variant", client)
+		}
+		if variants[client] == "canary" {
+			canary++
+		}
+	}
+	if canary == 0 || canary > 40 {
+		t.Errorf("expected about 5%% canary, got %d of 200", canary)
+	}
+
+	// Через API весь трафик переводится на canary
+	req, _ := http.NewRequest(http.MethodPatch, admin.URL+"/routes/web", strings.NewReader(`{"weights":{"stable":0,"canary":100}}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from PATCH, got %d", resp.StatusCode)
+	}
+	for client := range variants {
+		if got := get(client); got != "canary" {
+			t.Errorf("%s: expected canary after PATCH, got %s", client, got)
+		}
+	}
+
+	// Нулевая сумма весов отклоняется
+	req, _ = http.NewRequest(http.MethodPatch, admin.URL+"/routes/web", strings.NewReader(`{"weights":{"canary":0}}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for zero weights, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backends", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "[]")
+	})
+	ts := httptest.NewServer(server.BuildAdminHandler(mux, "secret"))
+	defer ts.Close()
+
+	get := func(auth string) int {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/backends", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", code)
+	}
+	if code := get("Bearer wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with wrong token, got %d", code)
+	}
+	if code := get("Bearer secret"); code != http.StatusOK {
+		t.Errorf("expected 200 with token, got %d", code)
+	}
+}
+
+func TestDrainWaitOverWriteTimeout(t *testing.T) {
+	b, _ := loadbalancer.NewFromSpec(loadbalancer.Spec{Name: "b1", URL: "http://127.0.0.1:1", Draining: true})
+	pool, _ := loadbalancer.NewPool([]loadbalancer.Backend{b}, loadbalancer.NewRoundRobin)
+	b.Acquire()
+	time.AfterFunc(700*time.Millisecond, b.Done)
+
+	mux := http.NewServeMux()
+	api.NewBackends(pool, loadbalancer.Spec{}, nil, nil).Register(mux)
+	ts := httptest.NewUnstartedServer(server.BuildAdminHandler(mux, ""))
+	ts.Config.WriteTimeout = 300 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	// Ответ приходит после окончания ожидания, хотя оно дольше WriteTimeout
+	resp, err := http.Get(ts.URL + "/backends/b1/drain?wait=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"drained":true`) {
+		t.Errorf("expected drained backend, got %s", body)
+	}
+}
+
+func TestClientCancelNotCounted(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	b, _ := loadbalancer.NewBackend(backend.URL)
+	bs := []loadbalancer.Backend{b}
+	px := proxy.New(loadbalancer.NewRoundRobin(bs), bs,
+		proxy.WithOutlierDetection(bs, proxy.OutlierConfig{ConsecutiveGatewayErrors: 1}),
+		proxy.WithCircuitBreaker(proxy.BreakerConfig{ConsecutiveFailures: 1}),
+	)
+
+	// Клиент уходит, не дождавшись ответа
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	px.ServeHTTP(httptest.NewRecorder(), req)
+
+	if st, _ := px.Breaker(b); st.State != proxy.BreakerClosed || st.Failures != 0 {
+		t.Errorf("client cancel counted as failure: %+v", st)
+	}
+	if px.Ejected(b) || !b.Alive() {
+		t.Error("backend ejected after client cancel")
+	}
+}
+
+func TestHealthEventsOverWriteTimeout(t *testing.T) {
+	var broken atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+
+	b, _ := loadbalancer.NewFromSpec(loadbalancer.Spec{Name: "b1", URL: backend.URL})
+	bs := []loadbalancer.Backend{b}
+	pool, _ := loadbalancer.NewPool(bs, loadbalancer.NewRoundRobin)
+	checker := healthcheck.New(bs, 50*time.Millisecond)
+	checker.Start()
+	defer checker.Stop()
+
+	mux := http.NewServeMux()
+	api.NewBackends(pool, loadbalancer.Spec{}, checker, nil).Register(mux)
+	ts := httptest.NewUnstartedServer(server.BuildAdminHandler(mux, ""))
+	ts.Config.WriteTimeout = 300 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/health/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Событие после WriteTimeout все равно доходит до клиента
+	time.AfterFunc(600*time.Millisecond, func() { broken.Store(true) })
+	buf := make([]byte, 512)
+	n, err := resp.Body.Read(buf)
+	if err != nil {
+		t.Fatalf("stream closed: %v", err)
+	}
+	if !strings.Contains(string(buf[:n]), "event: health") {
+		t.Errorf("expected health event, got %q", buf[:n])
+	}
+}
+
+func TestBreakerHalfOpenHashFallback(t *testing.T) {
+	var broken atomic.Bool
+	broken.Store(true)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		<-release
+		fmt.Fprint(w, "slow")
+	}))
+	defer slow.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "healthy")
+	}))
+	defer healthy.Close()
+
+	bSlow, _ := loadbalancer.NewBackend(slow.URL)
+	bHealthy, _ := loadbalancer.NewBackend(healthy.URL)
+	bs := []loadbalancer.Backend{bSlow, bHealthy}
+	key, _ := loadbalancer.NewKeyFunc("header", "X-User")
+	sel := loadbalancer.NewConsistentHash(bs, key, 0)
+
+	// Ключ, который hash отправляет на медленный сервер
+	user := ""
+	for i := 0; user == ""; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", fmt.Sprint(i))
+		if sel.Next(req) == bSlow {
+			user = fmt.Sprint(i)
+		}
+	}
+
+	px := proxy.New(sel, bs, proxy.WithCircuitBreaker(proxy.BreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         50 * time.Millisecond,
+	}))
+	ts := httptest.NewServer(px)
+	defer ts.Close()
+	defer close(release)
+
+	get := func() (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-User", user)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// Ошибка размыкает автомат, после таймаута он полуоткрыт
+	get()
+	time.Sleep(100 * time.Millisecond)
+	broken.Store(false)
+
+	// Единственный пробный запрос висит на медленном сервере
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-User", user)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	for bSlow.Conns() != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Тот же ключ обслуживает здоровый сервер, а не получает 503
+	if code, body := get(); code != http.StatusOK || body != "healthy" {
+		t.Errorf("expected fallback to healthy backend, got %d %q", code, body)
+	}
+}
+
+// Всегда выбирает первый сервер, как hash-алгоритм для одного ключа
+type firstSelector []loadbalancer.Backend
+
+func (s firstSelector) Next(*http.Request) loadbalancer.Backend { return s[0] }
+
+func TestFallbackPrefersSelectorScope(t *testing.T) {
+	var servers []*httptest.Server
+	defer func() {
+		for _, ts := range servers {
+			ts.Close()
+		}
+	}()
+	backend := func(spec loadbalancer.Spec) loadbalancer.Backend {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, spec.Name)
+		}))
+		servers = append(servers, ts)
+		spec.URL = ts.URL
+		b, err := loadbalancer.NewFromSpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	first := func(bs []loadbalancer.Backend) loadbalancer.Selector { return firstSelector(bs) }
+	get := func(px *proxy.Proxy) string {
+		ts := httptest.NewServer(px)
+		defer ts.Close()
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	t.Run("zone", func(t *testing.T) {
+		// Выбранный сервер занят, запрос остается в своей зоне
+		busy := backend(loadbalancer.Spec{Name: "busy", Zone: "a", MaxConns: 1})
+		remote := backend(loadbalancer.Spec{Name: "remote", Zone: "b"})
+		local := backend(loadbalancer.Spec{Name: "local", Zone: "a"})
+		bs := []loadbalancer.Backend{busy, remote, local}
+		busy.Acquire()
+		defer busy.Done()
+
+		px := proxy.New(loadbalancer.NewZoneAware(bs, "a", 0.5, first), bs)
+		if got := get(px); got != "local" {
+			t.Errorf("expected local zone backend, got %q", got)
+		}
+	})
+
+	t.Run("tier_and_ramp", func(t *testing.T) {
+		// В основной группе мало живых серверов, активна резервная
+		primary := backend(loadbalancer.Spec{Name: "primary"})
+		dead1 := backend(loadbalancer.Spec{Name: "dead1"})
+		dead2 := backend(loadbalancer.Spec{Name: "dead2"})
+		busy := backend(loadbalancer.Spec{Name: "busy", Priority: 1, MaxConns: 1})
+		warming := backend(loadbalancer.Spec{Name: "warming", Priority: 1, SlowStart: time.Hour})
+		loaded := backend(loadbalancer.Spec{Name: "loaded", Priority: 1})
+		bs := []loadbalancer.Backend{primary, dead1, dead2, busy, warming, loaded}
+		dead1.SetAlive(false)
+		dead2.SetAlive(false)
+		busy.Acquire()
+		defer busy.Done()
+		// Только что восстановился и получает малую долю трафика
+		warming.SetAlive(false)
+		warming.SetAlive(true)
+		loaded.Acquire()
+		defer loaded.Done()
+
+		px := proxy.New(loadbalancer.NewTiered(bs, 0.5, first), bs)
+		if got := get(px); got != "loaded" {
+			t.Errorf("expected active tier backend outside slow start, got %q", got)
+		}
+	})
+}
+
+func TestRetrySkipsTriedBackend(t *testing.T) {
+	// Адрес, на котором никто не слушает
+	ln := httptest.NewServer(http.NotFoundHandler())
+	deadURL := ln.URL
+	ln.Close()
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "alive")
+	}))
+	defer alive.Close()
+
+	bDead, _ := loadbalancer.NewBackend(deadURL)
+	bAlive, _ := loadbalancer.NewBackend(alive.URL)
+	bs := []loadbalancer.Backend{bDead, bAlive}
+	key, _ := loadbalancer.NewKeyFunc("header", "X-User")
+	sel := loadbalancer.NewMaglev(bs, key, 0)
+
+	// Ключ, который hash отправляет на недоступный сервер
+	user := ""
+	for i := 0; user == ""; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", fmt.Sprint(i))
+		if sel.Next(req) == bDead {
+			user = fmt.Sprint(i)
+		}
+	}
+
+	// С outlier detection ошибка соединения не помечает сервер мертвым сразу
+	px := proxy.New(sel, bs,
+		proxy.WithOutlierDetection(bs, proxy.OutlierConfig{ConsecutiveGatewayErrors: 100}),
+		proxy.WithRetry(proxy.RetryPolicy{Attempts: 2, OnConnectError: true}),
+	)
+	ts := httptest.NewServer(px)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("X-User", user)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "alive" {
+		t.Errorf("expected retry on other backend, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestHedgingWithRetryAndBreaker(t *testing.T) {
+	// Режимы медленного сервера: ошибка, ожидание отмены, быстрый ответ
+	const (
+		modeError = iota
+		modeHang
+		modeOK
+	)
+	var mode atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch mode.Load() {
+		case modeError:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case modeHang:
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+				fmt.Fprint(w, "slow")
+			}
+		default:
+			fmt.Fprint(w, "slow")
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "fast")
+	}))
+	defer fast.Close()
+
+	bSlow, _ := loadbalancer.NewBackend(slow.URL)
+	bFast, _ := loadbalancer.NewBackend(fast.URL)
+	bs := []loadbalancer.Backend{bSlow, bFast}
+	key, _ := loadbalancer.NewKeyFunc("header", "X-User")
+	sel := loadbalancer.NewMaglev(bs, key, 0)
+
+	// Ключ, который hash отправляет на медленный сервер
+	user := ""
+	for i := 0; user == ""; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", fmt.Sprint(i))
+		if sel.Next(req) == bSlow {
+			user = fmt.Sprint(i)
+		}
+	}
+
+	newProxy := func(opts ...proxy.Option) *httptest.Server {
+		opts = append(opts, proxy.WithHedging(proxy.HedgePolicy{Delay: 50 * time.Millisecond, MaxRate: 1}))
+		return httptest.NewServer(proxy.New(sel, bs, opts...))
+	}
+	get := func(ts *httptest.Server) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("X-User", user)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	t.Run("hash", func(t *testing.T) {
+		// Дубль уходит на другой сервер, хотя hash выбирает медленный
+		mode.Store(modeHang)
+		ts := newProxy()
+		defer ts.Close()
+		if code, body := get(ts); code != http.StatusOK || body != "fast" {
+			t.Errorf("expected hedged response, got %d %q", code, body)
+		}
+	})
+
+	t.Run("retry_on", func(t *testing.T) {
+		// Код из retry_on не передается клиенту, запрос повторяется на другом сервере
+		mode.Store(modeError)
+		ts := newProxy(proxy.WithRetry(proxy.RetryPolicy{Attempts: 2, OnStatus: []int{503}}))
+		defer ts.Close()
+		if code, body := get(ts); code != http.StatusOK || body != "fast" {
+			t.Errorf("expected retried response, got %d %q", code, body)
+		}
+	})
+
+	t.Run("no_retry", func(t *testing.T) {
+		// Ранний дубль после ошибки - это повтор, без разрешения политики его нет
+		ln := httptest.NewServer(http.NotFoundHandler())
+		dead, _ := loadbalancer.NewBackend(ln.URL)
+		ln.Close()
+		alive, _ := loadbalancer.NewBackend(fast.URL)
+		list := []loadbalancer.Backend{dead, alive}
+		px := proxy.New(firstSelector(list), list,
+			proxy.WithRetry(proxy.RetryPolicy{Attempts: 1}),
+			proxy.WithHedging(proxy.HedgePolicy{Delay: 50 * time.Millisecond, MaxRate: 0.0001}))
+		ts := httptest.NewServer(px)
+		defer ts.Close()
+		if code, body := get(ts); code != http.StatusBadGateway {
+			t.Errorf("expected 502 without retries, got %d %q", code, body)
+		}
+	})
+
+	t.Run("half_open", func(t *testing.T) {
+		// Отмененный дубль возвращает место пробного запроса автомата
+		mode.Store(modeError)
+		px := proxy.New(sel, bs,
+			proxy.WithCircuitBreaker(proxy.BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: 50 * time.Millisecond}),
+			proxy.WithHedging(proxy.HedgePolicy{Delay: 50 * time.Millisecond, MaxRate: 1}),
+		)
+		ts := httptest.NewServer(px)
+		defer ts.Close()
+
+		get(ts)
+		time.Sleep(100 * time.Millisecond)
+		if st, _ := px.Breaker(bSlow); st.State != proxy.BreakerHalfOpen {
+			t.Fatalf("expected half-open breaker, got %s", st.State)
+		}
+
+		mode.Store(modeHang)
+		if code, body := get(ts); code != http.StatusOK || body != "fast" {
+			t.Errorf("expected hedged response, got %d %q", code, body)
+		}
+		for bSlow.Conns() != 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		// Пробный запрос снова доходит до сервера и замыкает автомат
+		mode.Store(modeOK)
+		if code, body := get(ts); code != http.StatusOK || body != "slow" {
+			t.Errorf("expected trial request on recovered backend, got %d %q", code, body)
+		}
+		if st, _ := px.Breaker(bSlow); st.State != proxy.BreakerClosed {
+			t.Errorf("expected closed breaker, got %s", st.State)
+		}
+	})
+
+	t.Run("dead_backend", func(t *testing.T) {
+		// Без outlier и автомата ошибка соединения помечает сервер мертвым, как без дублей
+		ln := httptest.NewServer(http.NotFoundHandler())
+		dead, _ := loadbalancer.NewBackend(ln.URL)
+		ln.Close()
+		alive, _ := loadbalancer.NewBackend(fast.URL)
+		list := []loadbalancer.Backend{dead, alive}
+		px := proxy.New(loadbalancer.NewRoundRobin(list), list,
+			proxy.WithHedging(proxy.HedgePolicy{Delay: 50 * time.Millisecond, MaxRate: 1}))
+		ts := httptest.NewServer(px)
+		defer ts.Close()
+
+		for i := 0; i != 2; i++ {
+			if code, _ := get(ts); code != http.StatusOK {
+				t.Errorf("expected 200, got %d", code)
+			}
+		}
+		if dead.Alive() {
+			t.Error("expected unreachable backend marked dead")
+		}
+	})
+}
+
 func Benchmark(b *testing.B) {
 	client := &http.Client{Timeout: 2 * time.Second}
 	b.ResetTimer()
@@ -82,3 +1139,44 @@ func Benchmark(b *testing.B) {
 		}
 	})
 }
+
+// Сравнивает reverse proxy на каждый запрос с http.DefaultTransport
+// и долгоживущие reverse proxy с собственным пулом соединений
+func BenchmarkProxyTransport(b *testing.B) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	lb, _ := loadbalancer.NewBackend(backend.URL)
+	bs := []loadbalancer.Backend{lb}
+
+	perRequest := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httputil.NewSingleHostReverseProxy(lb.URL()).ServeHTTP(w, r)
+	})
+	handlers := map[string]http.Handler{
+		"per_request": perRequest,
+		"shared":      proxy.New(loadbalancer.NewRoundRobin(bs), bs),
+	}
+
+	for _, name := range []string{"per_request", "shared"} {
+		b.Run(name, func(b *testing.B) {
+			ts := httptest.NewServer(handlers[name])
+			defer ts.Close()
+			client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 256}}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Get(ts.URL)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					_, _ = io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			})
+		})
+	}
+}